@@ -0,0 +1,648 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The yabt binary runs the server by default and has subcommands for
+// operators. Every command reads the same settings as the server
+// (environment, secret files, CONFIG_FILE) and goes through the same storage
+// backend and parser.
+
+type command struct {
+	name    string
+	args    string // shown in help
+	summary string
+	run     func(cfg *Config, args []string) error
+}
+
+func cliCommands() []command {
+	return []command{
+		{"serve", "", "Run the web server (the default)", serveCommand},
+		{"apikey", "create|list|revoke", "Manage shortcut API keys for a budget", apikeyCommand},
+		{"parse", `"<text>"`, "Parse text into a transaction with the AI parser", parseCommand},
+		{"import", "<file.csv>", "Import transactions from CSV into a budget", importCommand},
+		{"export", "", "Export a budget's transactions as CSV or JSON", exportCommand},
+		{"migrate", "[up|down|status]", "Apply, roll back or list database migrations", migrateCommand},
+		{"check-config", "", "Validate the configuration and print the effective settings", checkConfigCommand},
+	}
+}
+
+// errUsage is returned once a usage message has been printed.
+var errUsage = errors.New("usage")
+
+// runCLI runs the command named by args and returns the process exit code.
+func runCLI(args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "help", "-h", "-help", "--help":
+		printCLIUsage(os.Stdout)
+		return 0
+	case "version", "--version":
+		fmt.Println("yabt", version, commit)
+		return 0
+	}
+
+	var cmd *command
+	for _, c := range cliCommands() {
+		if c.name == name {
+			cmd = &c
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "yabt: unknown command %q\n\n", name)
+		printCLIUsage(os.Stderr)
+		return 2
+	}
+
+	cfg := loadConfig(os.Getenv("CONFIG_FILE"))
+	logOutput := io.Writer(os.Stdout)
+	if cmd.name != "serve" {
+		// Keep stdout for the command's own output, and only report
+		// problems unless a log level was asked for
+		logOutput = os.Stderr
+		if !cfg.explicit("LOG_LEVEL") {
+			cfg.Log.Level = "warn"
+		}
+	}
+	// check-config reports problems itself
+	if cmd.name != "check-config" {
+		if err := cfg.validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+			return 1
+		}
+	}
+	configureLogging(cfg, logOutput, nil)
+	configureTracing(cfg.Tracing, cfg.Environment)
+
+	err := cmd.run(cfg, args)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "yabt %s: %v\n", cmd.name, err)
+	return 1
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: yabt [command] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	for _, c := range cliCommands() {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "yabt <command> -h" for a command's options. Settings are read as`)
+	fmt.Fprintln(w, "for the server: environment variables, *_FILE secrets and CONFIG_FILE.")
+}
+
+// newFlagSet returns a flag set whose usage message shows the command line
+// for name with args.
+func newFlagSet(name, args string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage: yabt %s", name)
+		hasFlags := false
+		flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprint(out, " [options]")
+		}
+		if args != "" {
+			fmt.Fprint(out, " "+args)
+		}
+		fmt.Fprintln(out)
+		if hasFlags {
+			fmt.Fprintln(out, "\nOptions:")
+			flags.PrintDefaults()
+		}
+	}
+	return flags
+}
+
+// parseFlags parses flags given before or after the positional arguments,
+// which it returns; everything after "--" is positional.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// usageError prints a problem with the command line and the usage message.
+func usageError(flags *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(flags.Output(), "yabt %s: %s\n", flags.Name(), fmt.Sprintf(format, args...))
+	flags.Usage()
+	return errUsage
+}
+
+// commandContext is cancelled by Ctrl-C or SIGTERM.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// openStore connects the storage backend for a command and returns it with
+// a function that closes it.
+func openStore(cfg *Config) (dataStore, func(), error) {
+	store, err := newDataStore(cfg.Storage, cfg.Supabase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return store, func() { closeStore(store) }, nil
+}
+
+// checkBudgetFlag validates a --budget value before anything connects.
+func checkBudgetFlag(flags *flag.FlagSet, budgetID string) error {
+	if budgetID == "" {
+		return usageError(flags, "--budget is required")
+	}
+	if _, err := uuid.Parse(budgetID); err != nil {
+		return usageError(flags, "invalid budget ID %q", budgetID)
+	}
+	return nil
+}
+
+// loadBudget loads the budget named by --budget.
+func loadBudget(ctx context.Context, store dataStore, budgetID string) (*budgetRecord, error) {
+	budget, err := store.getBudget(ctx, budgetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load budget: %w", err)
+	}
+	if budget == nil {
+		return nil, fmt.Errorf("budget %s not found", budgetID)
+	}
+	return budget, nil
+}
+
+func apikeyCommand(cfg *Config, args []string) error {
+	actions := map[string]func(*Config, []string) error{
+		"create": apikeyCreate,
+		"list":   apikeyList,
+		"revoke": apikeyRevoke,
+	}
+	if len(args) == 0 || actions[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, "Usage: yabt apikey create --budget <id> [--name <name>]")
+		fmt.Fprintln(os.Stderr, "       yabt apikey list --budget <id>")
+		fmt.Fprintln(os.Stderr, "       yabt apikey revoke <key id>")
+		return errUsage
+	}
+	return actions[args[0]](cfg, args[1:])
+}
+
+func apikeyCreate(cfg *Config, args []string) error {
+	flags := newFlagSet("apikey create", "")
+	budgetID := flags.String("budget", "", "budget the key adds transactions to")
+	name := flags.String("name", "iOS Shortcut", "label shown in Settings")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError(flags, "unexpected argument %q", positional[0])
+	}
+
+	if err := checkBudgetFlag(flags, *budgetID); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	budget, err := loadBudget(ctx, store, *budgetID)
+	if err != nil {
+		return err
+	}
+
+	value, err := newAPIKeyValue()
+	if err != nil {
+		return err
+	}
+	created, err := store.createAPIKey(ctx, apiKeyRecord{
+		UserID:   budget.UserID,
+		BudgetID: budget.ID,
+		Name:     strings.TrimSpace(*name),
+		KeyHash:  hashAPIKey(value),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	fmt.Printf("Created API key %s (%s)\n\n", created.ID, created.Name)
+	fmt.Println(value)
+	fmt.Println()
+	fmt.Println("Copy the key now: only its hash is stored, so it cannot be shown again.")
+	return nil
+}
+
+func apikeyList(cfg *Config, args []string) error {
+	flags := newFlagSet("apikey list", "")
+	budgetID := flags.String("budget", "", "budget whose keys to list")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError(flags, "unexpected argument %q", positional[0])
+	}
+
+	if err := checkBudgetFlag(flags, *budgetID); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	budget, err := loadBudget(ctx, store, *budgetID)
+	if err != nil {
+		return err
+	}
+	keys, err := store.listAPIKeys(ctx, budget.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tCREATED\tLAST USED")
+	for _, key := range keys {
+		lastUsed := "never"
+		if key.LastUsedAt != nil {
+			lastUsed = *key.LastUsedAt
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", key.ID, key.Name, key.CreatedAt, lastUsed)
+	}
+	return tw.Flush()
+}
+
+func apikeyRevoke(cfg *Config, args []string) error {
+	flags := newFlagSet("apikey revoke", "<key id>")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError(flags, "expected one key ID")
+	}
+	if _, err := uuid.Parse(positional[0]); err != nil {
+		return usageError(flags, "invalid key ID %q", positional[0])
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	deleted, err := store.deleteAPIKey(ctx, positional[0])
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if !deleted {
+		return fmt.Errorf("API key %s not found", positional[0])
+	}
+	fmt.Printf("Revoked API key %s\n", positional[0])
+	return nil
+}
+
+// newAPIKeyValue returns a new key in the format Settings generates.
+func newAPIKeyValue() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "yabt_" + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// parseCommand runs text through the same parser as the shortcut API,
+// without a budget, and prints the result.
+func parseCommand(cfg *Config, args []string) error {
+	flags := newFlagSet("parse", `"<text>"`)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	text := strings.TrimSpace(strings.Join(positional, " "))
+	if text == "" {
+		return usageError(flags, "text to parse is required")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	parsed, err := parseAITransaction(ctx, cfg.AI, text)
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(parsed)
+}
+
+// Columns of the CSV written by export and read by import
+var transactionCSVHeader = []string{"date", "account", "payee", "category", "memo", "amount", "cleared"}
+
+// importCommand adds transactions from a CSV file to a budget. Rows go
+// through the same matching as the shortcut API: accounts and categories are
+// matched by name and unknown payees are created.
+func importCommand(cfg *Config, args []string) error {
+	flags := newFlagSet("import", "<file.csv>")
+	budgetID := flags.String("budget", "", "budget to import into")
+	dryRun := flags.Bool("dry-run", false, "check the file without importing anything")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError(flags, "expected one CSV file (- for stdin)")
+	}
+
+	in := os.Stdin
+	if positional[0] != "-" {
+		f, err := os.Open(positional[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	rows, err := readTransactionCSV(in)
+	if err != nil {
+		return err
+	}
+
+	if err := checkBudgetFlag(flags, *budgetID); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	budget, err := loadBudget(ctx, store, *budgetID)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("%d transactions would be imported into budget %s\n", len(rows), budget.ID)
+		return nil
+	}
+
+	for i, row := range rows {
+		if _, err := recordParsedTransaction(ctx, store, budget.ID, row); err != nil {
+			return fmt.Errorf("row %d: %w (imported %d of %d)", i+2, err, i, len(rows))
+		}
+	}
+	fmt.Printf("Imported %d transactions into budget %s\n", len(rows), budget.ID)
+	return nil
+}
+
+// readTransactionCSV reads a CSV with a header row. Only date and amount
+// are required; column order does not matter and unknown columns are
+// ignored, so the output of export can be imported as is.
+func readTransactionCSV(in io.Reader) ([]parsedTransaction, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV has no %q column", required)
+		}
+	}
+
+	var rows []parsedTransaction
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		date := field("date")
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("row %d: date %q is not YYYY-MM-DD", line, date)
+		}
+		amount, ok := parseAmountValue(field("amount"))
+		if !ok {
+			return nil, fmt.Errorf("row %d: invalid amount %q", line, field("amount"))
+		}
+
+		row := parsedTransaction{
+			Amount:   amount,
+			Payee:    field("payee"),
+			Category: field("category"),
+			Account:  field("account"),
+			Date:     date,
+			Memo:     field("memo"),
+			Type:     "income",
+		}
+		if amount < 0 {
+			row.Type = "expense"
+		}
+		rows = append(rows, row)
+	}
+}
+
+func exportCommand(cfg *Config, args []string) error {
+	flags := newFlagSet("export", "")
+	budgetID := flags.String("budget", "", "budget to export")
+	format := flags.String("format", "csv", "csv or json")
+	output := flags.String("output", "", "file to write instead of stdout")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError(flags, "unexpected argument %q", positional[0])
+	}
+	if *format != "csv" && *format != "json" {
+		return usageError(flags, "unknown format %q", *format)
+	}
+
+	if err := checkBudgetFlag(flags, *budgetID); err != nil {
+		return err
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	store, closeStore, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	budget, err := loadBudget(ctx, store, *budgetID)
+	if err != nil {
+		return err
+	}
+	transactions, err := store.listTransactions(ctx, budget.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load transactions: %w", err)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if transactions == nil {
+			transactions = []exportedTransaction{}
+		}
+		return enc.Encode(transactions)
+	}
+
+	w := csv.NewWriter(out)
+	w.Write(transactionCSVHeader)
+	for _, tx := range transactions {
+		w.Write([]string{
+			tx.Date, tx.Account, tx.Payee, tx.Category, tx.Memo,
+			strconv.FormatFloat(tx.Amount, 'f', 2, 64),
+			strconv.FormatBool(tx.Cleared),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d transactions to %s\n", len(transactions), *output)
+	}
+	return nil
+}
+
+// checkConfigCommand validates the configuration the way the server does at
+// startup and prints the effective settings, with secrets masked.
+func checkConfigCommand(cfg *Config, args []string) error {
+	flags := newFlagSet("check-config", "")
+	checkStorage := flags.Bool("storage", false, "also connect to the storage backend")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError(flags, "unexpected argument %q", positional[0])
+	}
+
+	configErr := cfg.validate()
+
+	configFile := cfg.source.path
+	if configFile == "" {
+		configFile = "(none)"
+	}
+	fmt.Printf("Config file: %s\n", configFile)
+
+	settings := cfg.source.summary()
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Println("Settings:")
+	if len(keys) == 0 {
+		fmt.Println("  (all defaults)")
+	}
+	for _, key := range keys {
+		fmt.Printf("  %s=%s\n", key, settings[key])
+	}
+
+	if configErr != nil {
+		return fmt.Errorf("invalid configuration:\n%v", configErr)
+	}
+
+	if *checkStorage {
+		ctx, cancel := commandContext()
+		defer cancel()
+		store, closeStore, err := openStore(cfg)
+		if err != nil {
+			return fmt.Errorf("storage backend unavailable: %w", err)
+		}
+		defer closeStore()
+		if err := store.ping(ctx); err != nil {
+			return fmt.Errorf("storage backend unreachable: %w", err)
+		}
+		fmt.Printf("Storage: %s reachable\n", cfg.Storage.Backend)
+	}
+
+	fmt.Println("Configuration OK")
+	return nil
+}
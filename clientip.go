@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies whose forwarding headers are believed, from TRUSTED_PROXIES: a
+// comma-separated list of CIDRs or addresses, plus the shorthands
+// "loopback" and "private". The default only trusts a proxy on the same
+// host; trusting "private" would let anyone on the LAN or Docker network
+// pick their own client IP, so it has to be opted into.
+func loadTrustedProxies(src *configSource) []netip.Prefix {
+	prefixes, invalid := parseTrustedProxies(src.getEnv("TRUSTED_PROXIES", "loopback"))
+	for _, entry := range invalid {
+		src.invalid("TRUSTED_PROXIES", "invalid entry %q", entry)
+	}
+	return prefixes
+}
+
+var trustedProxyShorthands = map[string][]string{
+	"loopback": {"127.0.0.0/8", "::1/128"},
+	"private":  {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+}
+
+// parseTrustedProxies returns the prefixes in value and the entries that
+// are not addresses or CIDRs.
+func parseTrustedProxies(value string) (prefixes []netip.Prefix, invalid []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || item == "none" {
+			continue
+		}
+
+		candidates := []string{item}
+		if expanded, ok := trustedProxyShorthands[item]; ok {
+			candidates = expanded
+		}
+		for _, candidate := range candidates {
+			prefix, err := netip.ParsePrefix(candidate)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(candidate)
+				if addrErr != nil {
+					invalid = append(invalid, candidate)
+					continue
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes, invalid
+}
+
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientIPKey struct{}
+
+// withClientIP stores the resolved client address in ctx.
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIP returns the address a request came from: the value resolved by
+// loggingMiddleware when present, otherwise the peer address.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return resolveClientIP(r, nil, "")
+}
+
+// resolveClientIP only believes a forwarding header when the connection
+// comes from a trusted proxy, and then only the one header the proxy sets
+// (TRUSTED_PROXY_HEADER): xff, forwarded (RFC 7239) or x-real-ip. The
+// others are passed through from the client untouched and never read. A
+// forwarding chain is walked right to left, past every trusted hop, so
+// entries a client prepends itself are never used.
+func resolveClientIP(r *http.Request, trusted []netip.Prefix, header string) string {
+	remote, ok := parseHostAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !isTrustedProxy(remote, trusted) {
+		return remote.String()
+	}
+
+	var chain []string
+	switch header {
+	case "forwarded":
+		chain = forwardedFor(r.Header)
+	case "x-real-ip":
+		// A single address the proxy overwrites rather than appends to
+		if realIP, ok := parseHostAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ok {
+			return realIP.String()
+		}
+	default:
+		chain = xForwardedFor(r.Header)
+	}
+
+	// The nearest hop we can vouch for, in case the chain is all proxies
+	// or contains something unparseable
+	last := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseHostAddr(chain[i])
+		if !ok {
+			break
+		}
+		if !isTrustedProxy(addr, trusted) {
+			return addr.String()
+		}
+		last = addr
+	}
+	return last.String()
+}
+
+// xForwardedFor returns every X-Forwarded-For entry, across repeated headers.
+func xForwardedFor(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				chain = append(chain, item)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedFor returns the for= parameter of every Forwarded element.
+func forwardedFor(header http.Header) []string {
+	var chain []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					chain = append(chain, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return chain
+}
+
+// parseHostAddr parses an address with or without a port, including the
+// bracketed IPv6 form used by the Forwarded header.
+func parseHostAddr(value string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
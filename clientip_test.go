@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, _ := parseTrustedProxies("loopback,10.0.0.0/8")
+	tests := []struct {
+		name    string
+		remote  string
+		header  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "untrusted peer ignores every header",
+			remote:  "203.0.113.9:4000",
+			header:  "xff",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "Forwarded": "for=198.51.100.2", "X-Real-IP": "198.51.100.3"},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "xff from a trusted proxy",
+			remote:  "127.0.0.1:4000",
+			header:  "xff",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "client injects Forwarded behind an xff proxy",
+			remote:  "127.0.0.1:4000",
+			header:  "xff",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1", "Forwarded": "for=192.0.2.66"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "client injects X-Real-IP behind an xff proxy",
+			remote:  "127.0.0.1:4000",
+			header:  "xff",
+			headers: map[string]string{"X-Real-IP": "192.0.2.66"},
+			want:    "127.0.0.1",
+		},
+		{
+			name:    "prepended xff entries are skipped",
+			remote:  "10.0.0.2:4000",
+			header:  "xff",
+			headers: map[string]string{"X-Forwarded-For": "192.0.2.66, 198.51.100.1, 10.0.0.5"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "forwarded from a trusted proxy",
+			remote:  "127.0.0.1:4000",
+			header:  "forwarded",
+			headers: map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https`, "X-Forwarded-For": "192.0.2.66"},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "x-real-ip from a trusted proxy",
+			remote:  "127.0.0.1:4000",
+			header:  "x-real-ip",
+			headers: map[string]string{"X-Real-IP": "198.51.100.1", "X-Forwarded-For": "192.0.2.66"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "chain of trusted proxies only",
+			remote:  "127.0.0.1:4000",
+			header:  "xff",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.7, 10.0.0.5"},
+			want:    "10.0.0.7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+			if got := resolveClientIP(r, trusted, tt.header); got != tt.want {
+				t.Errorf("resolveClientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are read, in order of precedence, from:
+//
+//  1. environment variables (PORT, LOG_LEVEL, ...)
+//  2. <NAME>_FILE variables naming a file that holds the value, for Docker
+//     and Kubernetes secrets
+//  3. the YAML or TOML file named by CONFIG_FILE, where nested keys map to
+//     variable names (log: {level: debug} sets LOG_LEVEL)
+//  4. built-in defaults
+//
+// runCLI calls loadConfig once at startup. It reads every setting into a
+// typed Config, recording each value and any parse error, and validate
+// reports all problems at once so a typo fails fast with a clear message
+// instead of quietly falling back to a default. Each subsystem is then
+// handed its own section of the Config.
+
+// Config is every setting the server and the commands use.
+type Config struct {
+	Port                    string
+	Environment             string // NODE_ENV
+	MetricsToken            string
+	ReadinessCheckProviders bool
+	// How long provider check results are reused
+	ReadinessProviderCacheTTL time.Duration
+	// Proxies whose forwarding header is believed, and which header
+	TrustedProxies     []netip.Prefix
+	TrustedProxyHeader string
+
+	Server   serverConfig
+	TLS      tlsConfig
+	Security securityConfig
+	Static   staticConfig
+	Frontend frontendConfig
+	Features featuresConfig
+	Storage  storageConfig
+	Supabase supabaseConfig
+	Migrate  migrateConfig
+	AI       aiConfig
+	Log      logConfig
+	LogAPI   logAPIConfig
+	Tracing  tracingConfig
+	Jobs     jobsConfig
+
+	source *configSource
+}
+
+// loadConfig reads every setting from the sources above. Problems are
+// recorded rather than returned, for validate to report.
+func loadConfig(path string) *Config {
+	src := newConfigSource(path)
+	cfg := &Config{
+		Port:                      src.getEnv("PORT", "5177"),
+		Environment:               src.getEnv("NODE_ENV", "production"),
+		MetricsToken:              src.getEnv("METRICS_TOKEN", ""),
+		ReadinessCheckProviders:   src.getEnvBool("READINESS_CHECK_PROVIDERS", false),
+		ReadinessProviderCacheTTL: src.getEnvDuration("READINESS_PROVIDER_CACHE_TTL", time.Minute),
+		TrustedProxies:            loadTrustedProxies(src),
+		TrustedProxyHeader:        src.getEnvEnum("TRUSTED_PROXY_HEADER", "xff", "xff", "forwarded", "x-real-ip"),
+
+		Server:   loadServerConfig(src),
+		TLS:      loadTLSConfig(src),
+		Security: loadSecurityConfig(src),
+		Static:   loadStaticConfig(src),
+		Frontend: loadFrontendConfig(src),
+		Features: loadFeaturesConfig(src),
+		Storage:  loadStorageConfig(src),
+		Supabase: loadSupabaseConfig(src),
+		Migrate:  loadMigrateConfig(src),
+		AI:       loadAIConfig(src),
+		Log:      loadLogConfig(src),
+		LogAPI:   loadLogAPIConfig(src),
+		Tracing:  loadTracingConfig(src),
+		Jobs:     loadJobsConfig(src),
+
+		source: src,
+	}
+	return cfg
+}
+
+// configSource holds the configuration file and every setting read from it
+// or the environment, with where each value came from.
+type configSource struct {
+	path   string
+	values map[string]string // from the file, by variable name
+
+	mu       sync.Mutex
+	settings map[string]configSetting
+	errs     []error
+}
+
+type configSetting struct {
+	value  string
+	source string // env, secret file, config file, default
+}
+
+// Keys whose values never appear in the boot summary
+var secretSettingRegex = regexp.MustCompile(`(?i)(KEY|SECRET|TOKEN|PASSWORD|SALT)$|^DATABASE_URL$|WEBHOOK_URL$`)
+
+func newConfigSource(path string) *configSource {
+	c := &configSource{path: path, values: map[string]string{}, settings: map[string]configSetting{}}
+	if path == "" {
+		return c
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		c.invalid("CONFIG_FILE", "%v", err)
+		return c
+	}
+
+	var tree map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(raw, &tree)
+	case ".toml":
+		err = toml.Unmarshal(raw, &tree)
+	default:
+		err = errors.New("expected a .yaml, .yml or .toml file")
+	}
+	if err != nil {
+		c.invalid("CONFIG_FILE", "%s: %v", path, err)
+		return c
+	}
+	flattenConfig("", tree, c.values)
+	return c
+}
+
+// flattenConfig maps nested keys to variable names: {log: {level: x}}
+// becomes LOG_LEVEL=x. Lists become comma-separated values.
+func flattenConfig(prefix string, node interface{}, out map[string]string) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+			if prefix != "" {
+				name = prefix + "_" + name
+			}
+			flattenConfig(name, child, out)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+	default:
+		out[prefix] = fmt.Sprint(value)
+	}
+}
+
+// lookup finds key in the sources above; empty values count as unset.
+func (c *configSource) lookup(key string) (string, string, bool) {
+	if value := os.Getenv(key); value != "" {
+		return value, "env", true
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		return c.readSecret(key, path)
+	}
+	if value := c.values[key]; value != "" {
+		return value, "config file", true
+	}
+	if path := c.values[key+"_FILE"]; path != "" {
+		return c.readSecret(key, path)
+	}
+	return "", "", false
+}
+
+func (c *configSource) readSecret(key, path string) (string, string, bool) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		c.invalid(key+"_FILE", "%v", err)
+		return "", "", false
+	}
+	return strings.TrimRight(string(raw), "\r\n"), "secret file", true
+}
+
+func (c *configSource) get(key, defaultValue string) (string, bool) {
+	value, source, ok := c.lookup(key)
+	if !ok {
+		value, source = defaultValue, "default"
+	}
+	c.mu.Lock()
+	c.settings[key] = configSetting{value: value, source: source}
+	c.mu.Unlock()
+	return value, ok
+}
+
+// invalid records a configuration error for validate to report.
+func (c *configSource) invalid(key, format string, args ...interface{}) {
+	c.mu.Lock()
+	c.errs = append(c.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	c.mu.Unlock()
+}
+
+// summary returns every setting that is not at its default, with secrets
+// and URL credentials masked, for the boot log.
+func (c *configSource) summary() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := map[string]string{}
+	for key, setting := range c.settings {
+		if setting.source == "default" {
+			continue
+		}
+		value := setting.value
+		switch {
+		case secretSettingRegex.MatchString(key):
+			value = redactedValue
+		case strings.Contains(value, "://"):
+			if u, err := url.Parse(value); err == nil {
+				if _, hasPassword := u.User.Password(); hasPassword {
+					u.User = url.UserPassword(u.User.Username(), "xxxxx")
+					value = u.String()
+				}
+			}
+		}
+		out[key] = value + " (" + setting.source + ")"
+	}
+	return out
+}
+
+// explicit reports whether key was set anywhere rather than left at its
+// default.
+func (c *Config) explicit(key string) bool {
+	_, _, ok := c.source.lookup(key)
+	return ok
+}
+
+// validate reports every problem with the configuration at once.
+func (c *Config) validate() error {
+	src := c.source
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		src.invalid("PORT", "expected a port number, got %q", c.Port)
+	}
+	if c.TLS.HTTPPort != "" {
+		if n, err := strconv.Atoi(c.TLS.HTTPPort); err != nil || n < 1 || n > 65535 {
+			src.invalid("TLS_HTTP_PORT", "expected a port number, got %q", c.TLS.HTTPPort)
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		src.invalid("TLS_CERT_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.Storage.Backend == "postgres" && c.Storage.DatabaseURL == "" {
+		src.invalid("DATABASE_URL", "required when STORAGE_BACKEND is postgres")
+	}
+	if c.Migrate.OnStart && c.migrationDSN() == "" {
+		src.invalid("MIGRATE_ON_START", "needs SUPABASE_DB_URL or DATABASE_URL")
+	}
+	for key, value := range map[string]time.Duration{
+		"SHUTDOWN_TIMEOUT":             c.Server.ShutdownTimeout,
+		"SHUTDOWN_DRAIN_DELAY":         c.Server.ShutdownDrainDelay,
+		"TLS_RELOAD_INTERVAL":          c.TLS.ReloadInterval,
+		"READINESS_PROVIDER_CACHE_TTL": c.ReadinessProviderCacheTTL,
+	} {
+		if value < 0 {
+			src.invalid(key, "must not be negative")
+		}
+	}
+	if c.TLS.ReloadInterval == 0 {
+		src.invalid("TLS_RELOAD_INTERVAL", "must be greater than zero")
+	}
+	for _, job := range dataJobs(c.Jobs, nil) {
+		if job.enabled && job.interval <= 0 {
+			src.invalid(job.setting+"_INTERVAL", "must be greater than zero")
+		}
+	}
+	for key, value := range map[string]int{
+		"LOG_API_RATE_LIMIT":    c.LogAPI.RateLimit,
+		"LOG_API_IP_RATE_LIMIT": c.LogAPI.IPRateLimit,
+		"CSP_REPORT_RATE_LIMIT": c.Security.CSPReportRateLimit,
+	} {
+		if value < 0 {
+			src.invalid(key, "must not be negative; 0 disables the limit")
+		}
+	}
+	// Entries are charged per request, so a full batch has to fit
+	if c.LogAPI.RateLimit > 0 && c.LogAPI.RateBurst < c.LogAPI.MaxBatch {
+		src.invalid("LOG_API_RATE_BURST", "must be at least LOG_API_MAX_BATCH (%d)", c.LogAPI.MaxBatch)
+	}
+	if c.Jobs.Scheduled.MaxCatchUp < 1 {
+		src.invalid("SCHEDULED_TRANSACTIONS_MAX_CATCH_UP", "must be at least 1")
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	sort.Slice(src.errs, func(i, j int) bool { return src.errs[i].Error() < src.errs[j].Error() })
+	return errors.Join(src.errs...)
+}
+
+func (c *configSource) getEnv(key, defaultValue string) string {
+	value, _ := c.get(key, defaultValue)
+	return value
+}
+
+func (c *configSource) getEnvInt(key string, defaultValue int) int {
+	raw, ok := c.get(key, "")
+	if !ok {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		c.invalid(key, "expected an integer, got %q", raw)
+		return defaultValue
+	}
+	return value
+}
+
+func (c *configSource) getEnvFloat(key string, defaultValue float64) float64 {
+	raw, ok := c.get(key, "")
+	if !ok {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		c.invalid(key, "expected a number, got %q", raw)
+		return defaultValue
+	}
+	return value
+}
+
+func (c *configSource) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	raw, ok := c.get(key, "")
+	if !ok {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil {
+		c.invalid(key, "expected a duration such as 30s or 5m, got %q", raw)
+		return defaultValue
+	}
+	return value
+}
+
+func (c *configSource) getEnvBool(key string, defaultValue bool) bool {
+	raw, ok := c.get(key, "")
+	if !ok {
+		return defaultValue
+	}
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "true", "1", "yes", "on":
+		return true
+	case "false", "0", "no", "off":
+		return false
+	}
+	c.invalid(key, "expected true or false, got %q", raw)
+	return defaultValue
+}
+
+// getEnvEnum returns the setting when it is one of allowed.
+func (c *configSource) getEnvEnum(key, defaultValue string, allowed ...string) string {
+	raw, ok := c.get(key, "")
+	if !ok {
+		return defaultValue
+	}
+	value := strings.ToLower(strings.TrimSpace(raw))
+	for _, candidate := range allowed {
+		if value == candidate {
+			return value
+		}
+	}
+	c.invalid(key, "expected one of %s, got %q", strings.Join(allowed, ", "), raw)
+	return defaultValue
+}
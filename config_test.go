@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLogAPILimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"defaults", nil, ""},
+		{"limit disabled", map[string]string{"LOG_API_RATE_LIMIT": "0", "LOG_API_RATE_BURST": "1"}, ""},
+		{"burst below batch", map[string]string{"LOG_API_RATE_BURST": "20"}, "LOG_API_RATE_BURST: must be at least LOG_API_MAX_BATCH (50)"},
+		{"smaller batches", map[string]string{"LOG_API_RATE_BURST": "20", "LOG_API_MAX_BATCH": "20"}, ""},
+		{"negative limit", map[string]string{"LOG_API_IP_RATE_LIMIT": "-1"}, "LOG_API_IP_RATE_LIMIT: must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			err := loadConfig("").validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
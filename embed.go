@@ -0,0 +1,22 @@
+//go:build embed
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The built frontend, compiled into the binary with `go build -tags embed`
+// after `npm run build`.
+//
+//go:embed dist
+var distFiles embed.FS
+
+func init() {
+	sub, err := fs.Sub(distFiles, "dist")
+	if err != nil {
+		panic(err)
+	}
+	embeddedDist = sub
+}
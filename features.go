@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Feature names, as used in FEATURE_* settings, budgets.features and the
+// /api/features response
+const (
+	featureAIChat    = "aiChat"
+	featureVoice     = "voice"
+	featureShortcuts = "shortcuts"
+)
+
+// feature is one switchable capability. A feature is on when the server is
+// configured for it, the operator has not turned it off, the user is in its
+// rollout, and the budget has not opted out.
+type feature struct {
+	name  string
+	label string
+	// configured reports whether the server has what the feature needs
+	configured func() bool
+	rollout    featureRollout
+}
+
+// featureRollout comes from FEATURE_<NAME>: "on" (default), "off", or a
+// comma-separated list of user IDs or emails for a staged rollout.
+type featureRollout struct {
+	off   bool
+	users map[string]bool // nil means everyone
+}
+
+// featuresConfig holds the operator's FEATURE_* switches.
+type featuresConfig struct {
+	AIChat    featureRollout
+	Voice     featureRollout
+	Shortcuts featureRollout
+}
+
+func loadFeaturesConfig(src *configSource) featuresConfig {
+	return featuresConfig{
+		AIChat:    parseFeatureRollout(src.getEnv("FEATURE_AI_CHAT", "on")),
+		Voice:     parseFeatureRollout(src.getEnv("FEATURE_VOICE", "on")),
+		Shortcuts: parseFeatureRollout(src.getEnv("FEATURE_SHORTCUTS", "on")),
+	}
+}
+
+// newFeatureRegistry lists every feature with what it needs from cfg and
+// the storage backend, which is nil when unavailable.
+func newFeatureRegistry(cfg *Config, store dataStore) []*feature {
+	ai := cfg.AI
+	return []*feature{
+		{
+			name:       featureAIChat,
+			label:      "AI chat",
+			configured: func() bool { return ai.OllamaAPIKey != "" },
+			rollout:    cfg.Features.AIChat,
+		},
+		{
+			name:       featureVoice,
+			label:      "Voice transcription",
+			configured: func() bool { return ai.GroqAPIKey != "" },
+			rollout:    cfg.Features.Voice,
+		},
+		{
+			name:       featureShortcuts,
+			label:      "The shortcut API",
+			configured: func() bool { return store != nil && ai.OllamaAPIKey != "" },
+			rollout:    cfg.Features.Shortcuts,
+		},
+	}
+}
+
+func parseFeatureRollout(value string) featureRollout {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "on", "true":
+		return featureRollout{}
+	case "off", "false":
+		return featureRollout{off: true}
+	}
+
+	users := map[string]bool{}
+	for _, user := range strings.Split(value, ",") {
+		if user = strings.ToLower(strings.TrimSpace(user)); user != "" {
+			users[user] = true
+		}
+	}
+	return featureRollout{users: users}
+}
+
+func (a *app) lookupFeature(name string) *feature {
+	for _, f := range a.features {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// featureSubject is who is asking: any of the fields may be empty.
+type featureSubject struct {
+	userID string
+	email  string
+	budget *budgetRecord
+}
+
+// featureDecision explains whether a feature is on for a subject. Status is
+// the HTTP status a handler should answer with when it is off: 404 when the
+// server does not offer the feature at all, 403 when it is off for this
+// user or budget.
+type featureDecision struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	status  int
+	message string
+}
+
+func (f *feature) evaluate(subject featureSubject) featureDecision {
+	switch {
+	case !f.configured():
+		return featureDecision{Reason: "not_configured", status: http.StatusNotFound, message: f.label + " is not available on this server"}
+	case f.rollout.off:
+		return featureDecision{Reason: "disabled", status: http.StatusNotFound, message: f.label + " is not available on this server"}
+	case f.rollout.users != nil && !f.rollout.users[strings.ToLower(subject.userID)] && !f.rollout.users[strings.ToLower(subject.email)]:
+		return featureDecision{Reason: "not_in_rollout", status: http.StatusForbidden, message: f.label + " is not enabled for this account"}
+	}
+	if subject.budget != nil {
+		if enabled, ok := subject.budget.Features[f.name]; ok && !enabled {
+			return featureDecision{Reason: "disabled_for_budget", status: http.StatusForbidden, message: f.label + " is turned off for this budget"}
+		}
+	}
+	return featureDecision{Enabled: true}
+}
+
+// featureEnabled reports whether name is on for subject.
+func (a *app) featureEnabled(name string, subject featureSubject) bool {
+	f := a.lookupFeature(name)
+	return f != nil && f.evaluate(subject).Enabled
+}
+
+// requireFeature answers the request with 404 or 403 when name is off for
+// subject, and reports whether the handler may continue.
+func (a *app) requireFeature(w http.ResponseWriter, name string, subject featureSubject) bool {
+	f := a.lookupFeature(name)
+	if f == nil {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return false
+	}
+	decision := f.evaluate(subject)
+	if !decision.Enabled {
+		writeJSONError(w, decision.status, decision.message)
+		return false
+	}
+	return true
+}
+
+// requireServerFeature is requireFeature for checks made before the caller
+// is known: only the server configuration and operator switch apply.
+func (a *app) requireServerFeature(w http.ResponseWriter, name string) bool {
+	f := a.lookupFeature(name)
+	if f == nil {
+		writeJSONError(w, http.StatusNotFound, "Not found")
+		return false
+	}
+	decision := f.evaluate(featureSubject{})
+	if decision.status == http.StatusNotFound {
+		writeJSONError(w, decision.status, decision.message)
+		return false
+	}
+	return true
+}
+
+// sessionSubject identifies the caller from an optional Supabase session
+// token. Invalid or unverifiable tokens count as anonymous.
+func (a *app) sessionSubject(r *http.Request) featureSubject {
+	token := getAPIKeyFromRequest(r)
+	if token == "" {
+		return featureSubject{}
+	}
+	claims, err := verifySupabaseSession(r.Context(), a.cfg.Supabase, token)
+	if err != nil {
+		return featureSubject{}
+	}
+	return featureSubject{userID: claims.Sub, email: claims.Email}
+}
+
+// Feature states for the caller, optionally for one of their budgets
+// (?budgetId=). Anonymous callers see what is on for everyone.
+func (a *app) featuresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var subject featureSubject
+	if token := getAPIKeyFromRequest(r); token != "" {
+		claims, err := verifySupabaseSession(r.Context(), a.cfg.Supabase, token)
+		if errors.Is(err, errInvalidToken) {
+			writeJSONError(w, http.StatusUnauthorized, "Invalid or expired session")
+			return
+		}
+		if err == nil {
+			subject = featureSubject{userID: claims.Sub, email: claims.Email}
+		}
+	}
+
+	if budgetID := r.URL.Query().Get("budgetId"); budgetID != "" {
+		if subject.userID == "" {
+			writeJSONError(w, http.StatusUnauthorized, "Sign in to read budget features")
+			return
+		}
+		if _, err := uuid.Parse(budgetID); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid budgetId")
+			return
+		}
+		if a.store == nil {
+			writeJSONError(w, http.StatusInternalServerError, "Storage backend not configured")
+			return
+		}
+		budget, err := a.store.getBudget(r.Context(), budgetID)
+		if err != nil {
+			writeStoreError(w, err, "Failed to load budget")
+			return
+		}
+		// Someone else's budget looks the same as a missing one
+		if budget == nil || budget.UserID != subject.userID {
+			writeJSONError(w, http.StatusNotFound, "Budget not found")
+			return
+		}
+		subject.budget = budget
+	}
+
+	states := map[string]featureDecision{}
+	for _, f := range a.features {
+		states[f.name] = f.evaluate(subject)
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"features": states,
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const readinessTimeout = 5 * time.Second
+
+// Component statuses reported by the readiness endpoint
+const (
+	componentOK       = "ok"
+	componentFail     = "fail"
+	componentDisabled = "disabled"
+)
+
+// ComponentStatus is the result of a single readiness check.
+type ComponentStatus struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Latency  string `json:"latency,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ReadinessSummary is what anyone may read from the readiness endpoint:
+// the overall status and whether each check passed.
+type ReadinessSummary struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ReadinessResponse is the full readiness report, for callers holding
+// METRICS_TOKEN.
+type ReadinessResponse struct {
+	Status     string                     `json:"status"`
+	Timestamp  string                     `json:"timestamp"`
+	Version    string                     `json:"version"`
+	Commit     string                     `json:"commit"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+type readinessCheck struct {
+	name     string
+	critical bool
+	// Results are reused for READINESS_PROVIDER_CACHE_TTL, for checks
+	// that call out to third parties
+	cached bool
+	run    func(ctx context.Context) (string, error)
+}
+
+// readinessState is kept between probes: cached check results, and each
+// check's last status so failures are logged when they start and end
+// rather than on every probe.
+type readinessState struct {
+	mu       sync.Mutex
+	cache    map[string]cachedCheck
+	statuses map[string]string
+}
+
+type cachedCheck struct {
+	result  ComponentStatus
+	expires time.Time
+}
+
+func newReadinessState() *readinessState {
+	return &readinessState{cache: map[string]cachedCheck{}, statuses: map[string]string{}}
+}
+
+// buildCommit returns the injected git commit, falling back to the VCS
+// stamp Go embeds when building from a checkout.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+func (a *app) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "config", critical: true, run: a.checkConfig},
+		{name: "storage", critical: true, run: a.checkStorage},
+		{name: "spa", critical: true, run: a.checkSPA},
+	}
+
+	if a.cfg.ReadinessCheckProviders {
+		checks = append(checks,
+			readinessCheck{name: "ollama", cached: true, run: providerCheck(a.cfg.AI.OllamaAPIKey, "https://ollama.com/api/tags")},
+			readinessCheck{name: "groq", cached: true, run: providerCheck(a.cfg.AI.GroqAPIKey, "https://api.groq.com/openai/v1/models")},
+		)
+	}
+
+	return checks
+}
+
+// checkConfig verifies that every feature which is switched on has the
+// settings it needs.
+func (a *app) checkConfig(ctx context.Context) (string, error) {
+	var problems []string
+
+	switch a.cfg.Storage.Backend {
+	case "supabase", "":
+		if a.cfg.Supabase.ServiceRoleKey != "" && a.cfg.Supabase.URL == "" {
+			problems = append(problems, "SUPABASE_URL is required with SUPABASE_SERVICE_ROLE_KEY")
+		}
+	case "postgres":
+		if a.cfg.Storage.DatabaseURL == "" {
+			problems = append(problems, "DATABASE_URL is required for the postgres storage backend")
+		}
+	default:
+		problems = append(problems, "unknown STORAGE_BACKEND "+a.cfg.Storage.Backend)
+	}
+
+	// Shortcuts are available whenever storage is, and need the AI parser
+	if a.storageConfigured() && a.cfg.AI.OllamaAPIKey == "" {
+		problems = append(problems, "OLLAMA_API_KEY is required for the shortcut API")
+	}
+
+	if len(problems) > 0 {
+		return componentFail, errors.New(strings.Join(problems, "; "))
+	}
+	return componentOK, nil
+}
+
+func (a *app) checkStorage(ctx context.Context) (string, error) {
+	if a.store == nil {
+		if errors.Is(a.storeErr, errStoreNotConfigured) {
+			return componentDisabled, nil
+		}
+		if a.storeErr != nil {
+			return componentFail, a.storeErr
+		}
+		return componentDisabled, nil
+	}
+	if err := a.store.ping(ctx); err != nil {
+		return componentFail, err
+	}
+	return componentOK, nil
+}
+
+func (a *app) checkSPA(ctx context.Context) (string, error) {
+	if _, err := fs.Stat(a.frontend, "index.html"); err != nil {
+		return componentFail, err
+	}
+	return componentOK, nil
+}
+
+// providerCheck probes an AI provider with its API key. Providers are
+// reported but never critical: a third-party outage should not take the
+// whole server out of rotation.
+func providerCheck(apiKey, probeURL string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if apiKey == "" {
+			return componentDisabled, nil
+		}
+
+		req, err := newOutboundRequest(ctx, "GET", probeURL, nil)
+		if err != nil {
+			return componentFail, err
+		}
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return componentFail, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return componentFail, errors.New(resp.Status)
+		}
+		return componentOK, nil
+	}
+}
+
+// storageConfigured reports whether a storage backend was configured,
+// whether or not it could be reached.
+func (a *app) storageConfigured() bool {
+	return a.store != nil || (a.storeErr != nil && !errors.Is(a.storeErr, errStoreNotConfigured))
+}
+
+// runCheck runs a check, or reuses its cached result while fresh.
+func (a *app) runCheck(ctx context.Context, check readinessCheck) ComponentStatus {
+	if check.cached {
+		a.readiness.mu.Lock()
+		cached, ok := a.readiness.cache[check.name]
+		a.readiness.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return cached.result
+		}
+	}
+
+	start := time.Now()
+	status, err := check.run(ctx)
+	result := ComponentStatus{
+		Status:   status,
+		Critical: check.critical,
+		Latency:  time.Since(start).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if check.cached && ctx.Err() == nil {
+		a.readiness.mu.Lock()
+		a.readiness.cache[check.name] = cachedCheck{result: result, expires: time.Now().Add(a.cfg.ReadinessProviderCacheTTL)}
+		a.readiness.mu.Unlock()
+	}
+	return result
+}
+
+// logReadinessChanges logs checks that started or stopped failing since
+// the previous probe, with the error detail the public response leaves out.
+func (a *app) logReadinessChanges(components map[string]ComponentStatus) {
+	a.readiness.mu.Lock()
+	defer a.readiness.mu.Unlock()
+
+	for name, component := range components {
+		previous := a.readiness.statuses[name]
+		a.readiness.statuses[name] = component.Status
+		switch {
+		case component.Status == componentFail && previous != componentFail:
+			logger.Warn("Readiness check failing", "check", name, "critical", component.Critical, "error", component.Error)
+		case component.Status != componentFail && previous == componentFail:
+			logger.Info("Readiness check recovered", "check", name)
+		}
+	}
+}
+
+// Readiness handler: runs every check concurrently and returns 503 when a
+// critical component is failing or the server is draining for shutdown.
+// Anyone gets the status and whether each check passed; errors, latencies
+// and the build need METRICS_TOKEN, and failures are logged.
+func (a *app) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := a.readinessChecks()
+	components := make(map[string]ComponentStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check readinessCheck) {
+			defer wg.Done()
+			result := a.runCheck(ctx, check)
+
+			mu.Lock()
+			components[check.name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+	a.logReadinessChanges(components)
+
+	overall := "ready"
+	for _, component := range components {
+		if component.Status != componentFail {
+			continue
+		}
+		if component.Critical {
+			overall = "not_ready"
+			break
+		}
+		overall = "degraded"
+	}
+	if a.lifecycle.draining.Load() {
+		overall = "draining"
+	}
+
+	status := http.StatusOK
+	if overall == "not_ready" || overall == "draining" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if !a.hasMetricsToken(r) {
+		summary := ReadinessSummary{Status: overall, Checks: make(map[string]string, len(components))}
+		for name, component := range components {
+			summary.Checks[name] = componentOK
+			if component.Status == componentFail {
+				summary.Checks[name] = componentFail
+			}
+		}
+		writeJSON(w, status, summary)
+		return
+	}
+
+	writeJSON(w, status, ReadinessResponse{
+		Status:     overall,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Version:    version,
+		Commit:     buildCommit(),
+		Components: components,
+	})
+}
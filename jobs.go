@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"time"
+	_ "time/tzdata" // JOBS_TIMEZONE must resolve in minimal containers
+)
+
+// Data jobs are periodic tasks that work on budgets directly through the
+// storage backend, such as posting scheduled transactions. Each runs once
+// at startup and then on its own interval until shutdown. Jobs must be
+// safe to run from several replicas at once.
+
+// jobsConfig is the data jobs section of the configuration.
+type jobsConfig struct {
+	// Timezone that decides which day it is for date-based jobs
+	Location  *time.Location
+	Scheduled scheduledConfig
+	NetWorth  netWorthConfig
+	Rollover  rolloverConfig
+}
+
+func loadJobsConfig(src *configSource) jobsConfig {
+	name := src.getEnv("JOBS_TIMEZONE", "UTC")
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		src.invalid("JOBS_TIMEZONE", "unknown timezone %q", name)
+		location = time.UTC
+	}
+	return jobsConfig{
+		Location:  location,
+		Scheduled: loadScheduledConfig(src),
+		NetWorth:  loadNetWorthConfig(src),
+		Rollover:  loadRolloverConfig(src),
+	}
+}
+
+// Layout of date columns
+const dateLayout = "2006-01-02"
+
+type dataJob struct {
+	name     string
+	setting  string // switch that enables the job; SETTING_INTERVAL sets its interval
+	enabled  bool
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+func dataJobs(cfg jobsConfig, store dataStore) []dataJob {
+	return []dataJob{
+		{
+			name:     "scheduled-transactions",
+			setting:  "SCHEDULED_TRANSACTIONS",
+			enabled:  cfg.Scheduled.Enabled,
+			interval: cfg.Scheduled.Interval,
+			run: func(ctx context.Context) error {
+				return runScheduledTransactions(ctx, store, cfg.today(), cfg.Scheduled)
+			},
+		},
+		{
+			name:     "net-worth-snapshots",
+			setting:  "NET_WORTH_SNAPSHOTS",
+			enabled:  cfg.NetWorth.Enabled,
+			interval: cfg.NetWorth.Interval,
+			run: func(ctx context.Context) error {
+				return runNetWorthSnapshots(ctx, store, cfg.today(), cfg.NetWorth)
+			},
+		},
+		{
+			name:     "month-rollover",
+			setting:  "MONTH_ROLLOVER",
+			enabled:  cfg.Rollover.Enabled,
+			interval: cfg.Rollover.Interval,
+			run: func(ctx context.Context) error {
+				return runMonthRollover(ctx, store, cfg.today(), cfg.Rollover)
+			},
+		},
+	}
+}
+
+// today returns the current date in JOBS_TIMEZONE as midnight UTC, so it
+// compares and formats like a date column.
+func (c jobsConfig) today() time.Time {
+	location := c.Location
+	if location == nil {
+		location = time.UTC
+	}
+	year, month, day := time.Now().In(location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// startDataJobs starts every enabled job. Jobs need storage, so nothing
+// starts when the backend is unavailable.
+func startDataJobs(lc *lifecycle, cfg jobsConfig, store dataStore) {
+	if store == nil {
+		return
+	}
+	for _, job := range dataJobs(cfg, store) {
+		if !job.enabled {
+			continue
+		}
+		job := job
+		lc.goJob(job.name, func(ctx context.Context) {
+			runEvery(ctx, job)
+		})
+	}
+}
+
+// runEvery runs job now and then every interval until ctx is done.
+func runEvery(ctx context.Context, job dataJob) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		runDataJob(ctx, job)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runDataJob(ctx context.Context, job dataJob) {
+	ctx, span := startSpan(ctx, "job "+job.name, spanKindInternal)
+	defer span.finish()
+
+	start := time.Now()
+	err := job.run(ctx)
+	jobRunDuration.observe(time.Since(start).Seconds(), job.name)
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		if ctx.Err() != nil {
+			result = "cancelled"
+		}
+		span.setError(err)
+		logger.Error("Background job failed", "job", job.name, "error", err)
+	}
+	jobRunsTotal.inc(job.name, result)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errInvalidToken = errors.New("invalid or expired token")
+	// errTokenUnverifiable means no way to check the token is configured.
+	errTokenUnverifiable = errors.New("token verification not configured")
+)
+
+const verifiedTokenTTL = 5 * time.Minute
+
+// Tokens confirmed by the Supabase Auth API, so repeated calls from the same
+// session do not each cost a round trip.
+var verifiedTokens = struct {
+	sync.Mutex
+	users map[string]verifiedToken
+}{users: map[string]verifiedToken{}}
+
+type verifiedToken struct {
+	claims  jwtClaims
+	expires time.Time
+}
+
+type jwtClaims struct {
+	Sub   string `json:"sub"`
+	Email string `json:"email"`
+	Exp   int64  `json:"exp"`
+}
+
+func (c jwtClaims) user() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Sub
+}
+
+// verifySupabaseJWT checks a Supabase access token and returns its user
+// (email, or subject when there is none). Tokens are verified locally with
+// SUPABASE_JWT_SECRET when set, otherwise against the Supabase Auth API.
+func verifySupabaseJWT(ctx context.Context, cfg supabaseConfig, token string) (string, error) {
+	claims, err := verifySupabaseSession(ctx, cfg, token)
+	if err != nil {
+		return "", err
+	}
+	return claims.user(), nil
+}
+
+// verifySupabaseSession is verifySupabaseJWT returning the claims, for
+// callers that need the user ID as well as the email.
+func verifySupabaseSession(ctx context.Context, cfg supabaseConfig, token string) (jwtClaims, error) {
+	if cfg.JWTSecret != "" {
+		return verifyHS256(token, []byte(cfg.JWTSecret))
+	}
+
+	if cfg.URL == "" || cfg.ServiceRoleKey == "" {
+		return jwtClaims{}, errTokenUnverifiable
+	}
+	return verifyWithSupabaseAuth(ctx, cfg, token)
+}
+
+// verifiedSubject returns the subject of a token that can be checked
+// without a network call: signed with SUPABASE_JWT_SECRET, or recently
+// confirmed by the Supabase Auth API. Anything else yields "".
+func verifiedSubject(cfg supabaseConfig, token string) string {
+	if cfg.JWTSecret != "" {
+		claims, err := verifyHS256(token, []byte(cfg.JWTSecret))
+		if err != nil {
+			return ""
+		}
+		return claims.Sub
+	}
+	claims, _ := cachedClaims(verifiedTokenKey(token))
+	return claims.Sub
+}
+
+func verifyHS256(token string, secret []byte) (jwtClaims, error) {
+	var claims jwtClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errInvalidToken
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(header, &h) != nil || h.Alg != "HS256" {
+		return claims, errInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return claims, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return claims, errInvalidToken
+	}
+	if claims.Exp != 0 && time.Now().Unix() >= claims.Exp {
+		return claims, errInvalidToken
+	}
+	return claims, nil
+}
+
+func verifyWithSupabaseAuth(ctx context.Context, cfg supabaseConfig, token string) (jwtClaims, error) {
+	cacheKey := verifiedTokenKey(token)
+	if claims, ok := cachedClaims(cacheKey); ok {
+		return claims, nil
+	}
+
+	req, err := newOutboundRequest(ctx, "GET", strings.TrimRight(cfg.URL, "/")+"/auth/v1/user", nil)
+	if err != nil {
+		return jwtClaims{}, err
+	}
+	req.Header.Set("apikey", cfg.ServiceRoleKey)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		observeOutbound("supabase_auth", start, false)
+		return jwtClaims{}, err
+	}
+	defer resp.Body.Close()
+	observeOutbound("supabase_auth", start, resp.StatusCode < 500)
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return jwtClaims{}, errInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return jwtClaims{}, fmt.Errorf("supabase auth returned %s", resp.Status)
+	}
+
+	var user struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return jwtClaims{}, err
+	}
+	claims := jwtClaims{Sub: user.ID, Email: user.Email}
+
+	// Never cache past the token's own expiry
+	expires := time.Now().Add(verifiedTokenTTL)
+	if exp := tokenExpiry(token); !exp.IsZero() && exp.Before(expires) {
+		expires = exp
+	}
+
+	verifiedTokens.Lock()
+	now := time.Now()
+	for key, entry := range verifiedTokens.users {
+		if now.After(entry.expires) {
+			delete(verifiedTokens.users, key)
+		}
+	}
+	verifiedTokens.users[cacheKey] = verifiedToken{claims: claims, expires: expires}
+	verifiedTokens.Unlock()
+
+	return claims, nil
+}
+
+func verifiedTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedClaims returns the claims the Supabase Auth API confirmed for a
+// token, while they are fresh.
+func cachedClaims(cacheKey string) (jwtClaims, bool) {
+	verifiedTokens.Lock()
+	cached, ok := verifiedTokens.users[cacheKey]
+	verifiedTokens.Unlock()
+	if !ok || !time.Now().Before(cached.expires) {
+		return jwtClaims{}, false
+	}
+	return cached.claims, true
+}
+
+// tokenExpiry reads the unverified exp claim, for cache bookkeeping only.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims jwtClaims
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func signHS256(t *testing.T, payload, secret string) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	body := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(header + "." + body))
+	return header + "." + body + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifiedSubject(t *testing.T) {
+	withSecret := supabaseConfig{JWTSecret: "secret"}
+	payload := `{"sub":"u1","email":"a@example.com"}`
+
+	confirmed := signHS256(t, `{"sub":"u2"}`, "unknown")
+	verifiedTokens.Lock()
+	verifiedTokens.users[verifiedTokenKey(confirmed)] = verifiedToken{claims: jwtClaims{Sub: "u2"}, expires: time.Now().Add(time.Minute)}
+	verifiedTokens.Unlock()
+
+	tests := []struct {
+		name  string
+		cfg   supabaseConfig
+		token string
+		want  string
+	}{
+		{"signed with the secret", withSecret, signHS256(t, payload, "secret"), "u1"},
+		{"forged signature", withSecret, signHS256(t, payload, "guess"), ""},
+		{"expired", withSecret, signHS256(t, `{"sub":"u1","exp":1}`, "secret"), ""},
+		{"not a JWT", withSecret, "abc", ""},
+		{"unverified without a secret", supabaseConfig{}, signHS256(t, payload, "guess"), ""},
+		{"confirmed by Supabase Auth", supabaseConfig{}, confirmed, "u2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifiedSubject(tt.cfg, tt.token); got != tt.want {
+				t.Errorf("verifiedSubject = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// serverConfig holds the HTTP server limits and shutdown timing. The write
+// timeout has to outlast the longest route deadline (shortcutDeadline) so
+// slow AI parses can still be answered.
+type serverConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// Time between failing readiness and closing listeners, so load
+	// balancers stop routing here before connections are refused
+	ShutdownDrainDelay time.Duration
+	// Time allowed for in-flight requests and background jobs to finish
+	ShutdownTimeout time.Duration
+}
+
+func loadServerConfig(src *configSource) serverConfig {
+	return serverConfig{
+		ReadHeaderTimeout:  src.getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:        src.getEnvDuration("SERVER_READ_TIMEOUT", 60*time.Second),
+		WriteTimeout:       src.getEnvDuration("SERVER_WRITE_TIMEOUT", 90*time.Second),
+		IdleTimeout:        src.getEnvDuration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:     src.getEnvInt("SERVER_MAX_HEADER_BYTES", 256<<10),
+		ShutdownDrainDelay: src.getEnvDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		ShutdownTimeout:    src.getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+	}
+}
+
+// lifecycle tracks shutdown state shared by the servers, readiness and
+// background jobs.
+type lifecycle struct {
+	// Set once shutdown begins; readiness reports not ready from then on
+	draining atomic.Bool
+
+	// Background jobs share a context that is cancelled at shutdown
+	jobsCtx  context.Context
+	stopJobs context.CancelFunc
+	jobsWG   sync.WaitGroup
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{jobsCtx: ctx, stopJobs: cancel}
+}
+
+// goJob runs fn in the background until shutdown. Jobs should return
+// promptly once ctx is done; shutdown waits for them up to SHUTDOWN_TIMEOUT.
+func (lc *lifecycle) goJob(name string, fn func(ctx context.Context)) {
+	lc.jobsWG.Add(1)
+	go func() {
+		defer lc.jobsWG.Done()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				logger.Error("Background job panicked", "job", name, "panic", recovered, "stack", string(debug.Stack()))
+			}
+		}()
+		fn(lc.jobsCtx)
+	}()
+}
+
+func newHTTPServer(addr string, handler http.Handler, cfg serverConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+}
+
+// serve runs the servers until one fails or SIGINT/SIGTERM arrives, then
+// shuts them down in order: fail readiness, drain, stop accepting and
+// finish in-flight requests, and stop background jobs. The caller flushes
+// logs and traces and closes storage afterwards.
+func serve(cfg serverConfig, lc *lifecycle, servers ...*serverRunner) error {
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s *serverRunner) {
+			if err := s.run(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(s)
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var serveErr error
+	select {
+	case sig := <-signals:
+		logger.Info("Shutting down", "signal", sig.String(), "drainDelay", cfg.ShutdownDrainDelay)
+	case serveErr = <-errs:
+		logger.Error("Server failed", "error", serveErr)
+	}
+
+	lc.draining.Store(true)
+
+	// Give load balancers time to notice; a second signal skips the wait
+	if serveErr == nil && cfg.ShutdownDrainDelay > 0 {
+		select {
+		case <-time.After(cfg.ShutdownDrainDelay):
+		case <-signals:
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *serverRunner) {
+			defer wg.Done()
+			if err := s.server.Shutdown(ctx); err != nil {
+				logger.Warn("Server did not shut down cleanly", "addr", s.server.Addr, "error", err)
+				s.server.Close()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	lc.stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		lc.jobsWG.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		logger.Warn("Background jobs did not stop in time")
+	}
+	return serveErr
+}
+
+// serverRunner pairs a server with the call that starts it, so plain and
+// TLS listeners are shut down the same way.
+type serverRunner struct {
+	server *http.Server
+	run    func() error
+}
+
+func listenAndServe(server *http.Server) *serverRunner {
+	return &serverRunner{server: server, run: server.ListenAndServe}
+}
+
+// flushBackground delivers queued logs and traces before the process exits.
+func flushBackground(sinks []*logSink) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	closeLogSinks(ctx, sinks)
+	shutdownTracing(ctx)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Server logs go through log/slog. logHandler renders every record in the
+// LogEntry schema, so stdout, the log webhook and the other sinks keep
+// receiving the same fields they always have.
+
+// Root logger; request handlers should use loggerFrom(ctx) instead. Until
+// configureLogging runs it logs at info level to stdout.
+var logger = slog.New(&logHandler{
+	out:         os.Stdout,
+	level:       logLevels["info"],
+	environment: "production",
+	redaction:   newRedactionPolicy(redactConfig{}),
+})
+
+// logConfig is the logging section of the configuration.
+type logConfig struct {
+	Level       string
+	RequestBody bool // log request and response bodies
+	Redact      redactConfig
+	Sinks       logSinksConfig
+}
+
+func loadLogConfig(src *configSource) logConfig {
+	return logConfig{
+		Level:       src.getEnvEnum("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+		RequestBody: src.getEnvBool("LOG_REQUEST_BODY", true),
+		Redact:      loadRedactConfig(src),
+		Sinks:       loadLogSinksConfig(src),
+	}
+}
+
+// configureLogging points the root logger at out, with the configured level
+// and redaction, shipping entries to sinks as well. runCLI calls it before
+// anything is logged, and serveCommand again once the sinks have started.
+func configureLogging(cfg *Config, out io.Writer, sinks []*logSink) {
+	logger = slog.New(&logHandler{
+		out:         out,
+		level:       logLevels[cfg.Log.Level],
+		environment: cfg.Environment,
+		redaction:   newRedactionPolicy(cfg.Log.Redact),
+		sinks:       sinks,
+	})
+	// Route the standard logger through the structured handler too
+	slog.SetDefault(logger)
+}
+
+type loggerKey struct{}
+
+// withLogger stores a request-scoped logger in ctx.
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the logger set by loggingMiddleware, which carries the
+// request ID, trace ID, route and user, or the root logger outside a request.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+// parseLogLevel maps the level names used in configuration and by the
+// client log API to slog levels.
+func parseLogLevel(name string) (slog.Level, bool) {
+	switch name {
+	case "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return slog.LevelInfo, false
+}
+
+func logLevelName(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "error"
+	case level >= slog.LevelWarn:
+		return "warn"
+	case level >= slog.LevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
+
+// logHandler is a slog.Handler that writes LogEntry JSON to out and ships
+// it to the sinks.
+type logHandler struct {
+	out         io.Writer
+	level       int // for out; each sink has its own
+	environment string
+	redaction   *redactionPolicy
+	sinks       []*logSink
+
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *logHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.wantsLevel(logLevels[logLevelName(level)])
+}
+
+// wantsLevel reports whether out or any sink logs at this level.
+func (h *logHandler) wantsLevel(level int) bool {
+	if level <= h.level {
+		return true
+	}
+	for _, sink := range h.sinks {
+		if level <= sink.minLevel {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, attr := range attrs {
+		next.attrs = append(next.attrs, slog.Attr{Key: h.prefix + attr.Key, Value: attr.Value})
+	}
+	return &next
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.prefix = h.prefix + name + "."
+	return &next
+}
+
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	entry := &LogEntry{
+		Timestamp:   record.Time.UTC().Format(time.RFC3339),
+		Level:       logLevelName(record.Level),
+		Message:     record.Message,
+		Service:     "yabt",
+		Environment: h.environment,
+	}
+
+	for _, attr := range h.attrs {
+		entry.set(attr.Key, attr.Value)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		entry.set(h.prefix+attr.Key, attr.Value)
+		return true
+	})
+
+	// Fill correlation IDs from ctx when logging with the root logger
+	if ctx != nil {
+		if entry.RequestID == "" {
+			entry.RequestID = requestIDFromContext(ctx)
+		}
+		if entry.TraceID == "" {
+			entry.TraceID = spanFromContext(ctx).traceID()
+		}
+	}
+
+	h.redaction.apply(entry)
+
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling log entry: %v\n", err)
+		return err
+	}
+
+	if logLevels[entry.Level] <= h.level {
+		fmt.Fprintln(h.out, string(jsonBytes))
+	}
+
+	// Ship to the configured sinks, each with its own level and path filter
+	shipToSinks(h.sinks, entry)
+	return nil
+}
+
+// set maps an attribute onto the matching LogEntry field; anything else is
+// kept in Fields and written alongside them.
+func (e *LogEntry) set(key string, value slog.Value) {
+	value = value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, attr := range value.Group() {
+			e.set(key+"."+attr.Key, attr.Value)
+		}
+		return
+	}
+
+	switch key {
+	case "requestId":
+		e.RequestID = value.String()
+	case "traceId":
+		e.TraceID = value.String()
+	case "route":
+		e.Route = value.String()
+	case "method":
+		e.Method = value.String()
+	case "path":
+		e.Path = value.String()
+	case "statusCode":
+		e.StatusCode = int(value.Int64())
+	case "responseTime":
+		e.ResponseTime = value.String()
+	case "ip":
+		e.IP = value.String()
+	case "userAgent":
+		e.UserAgent = value.String()
+	case "referer":
+		e.Referer = value.String()
+	case "user":
+		e.User = value.String()
+	case "error":
+		e.Error = logValueString(value)
+	case "body":
+		e.Body = value.Any()
+	case "timestamp", "level", "message", "service", "environment":
+		// Reserved for the handler; keep the caller's value without clobbering it
+		e.set("attr."+key, value)
+	default:
+		if e.Fields == nil {
+			e.Fields = map[string]interface{}{}
+		}
+		e.Fields[key] = logValueJSON(value)
+	}
+}
+
+func logValueString(value slog.Value) string {
+	if err, ok := value.Any().(error); ok {
+		return err.Error()
+	}
+	return value.String()
+}
+
+// logValueJSON converts values that do not marshal usefully on their own.
+func logValueJSON(value slog.Value) interface{} {
+	switch value.Kind() {
+	case slog.KindDuration:
+		return value.Duration().String()
+	case slog.KindTime:
+		return value.Time().UTC().Format(time.RFC3339)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return value.Any()
+}
+
+// MarshalJSON writes the fixed fields followed by any extra attributes.
+func (e *LogEntry) MarshalJSON() ([]byte, error) {
+	type plainEntry LogEntry
+	b, err := json.Marshal((*plainEntry)(e))
+	if err != nil || len(e.Fields) == 0 {
+		return b, err
+	}
+
+	extra, err := json.Marshal(e.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 2 || len(extra) < 2 {
+		return nil, errors.New("unexpected log entry encoding")
+	}
+	out := append(b[:len(b)-1:len(b)-1], ',')
+	return append(out, extra[1:]...), nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logShipConfig is the log shipping configuration, shared by every log sink.
+type logShipConfig struct {
+	QueueSize     int
+	FlushInterval time.Duration
+	Workers       int
+	MaxRetries    int
+	SpoolDir      string
+	SpoolMaxBytes int64
+}
+
+func loadLogShipConfig(src *configSource) logShipConfig {
+	return logShipConfig{
+		QueueSize:     src.getEnvInt("LOG_WEBHOOK_QUEUE_SIZE", 1000),
+		FlushInterval: src.getEnvDuration("LOG_WEBHOOK_FLUSH_INTERVAL", 2*time.Second),
+		Workers:       src.getEnvInt("LOG_WEBHOOK_WORKERS", 2),
+		MaxRetries:    src.getEnvInt("LOG_WEBHOOK_MAX_RETRIES", 3),
+		SpoolDir:      src.getEnv("LOG_WEBHOOK_SPOOL_DIR", ""),
+		SpoolMaxBytes: int64(src.getEnvInt("LOG_WEBHOOK_SPOOL_MAX_MB", 50)) << 20,
+	}
+}
+
+const (
+	logShipRetryBase     = 500 * time.Millisecond
+	logShipRetryMaxDelay = 10 * time.Second
+	logShipReplayEvery   = 30 * time.Second
+)
+
+// logRecord is one formatted log line waiting to be delivered to a sink.
+type logRecord struct {
+	Level string    `json:"level"`
+	Time  time.Time `json:"time"`
+	Line  string    `json:"line"`
+}
+
+// logTransport delivers a batch of records to a sink's destination.
+type logTransport interface {
+	send(ctx context.Context, batch []logRecord) error
+}
+
+// logShipper delivers records to a transport from a bounded queue. Records
+// are batched by count or time and retried with backoff. Batches that still
+// fail are spooled to disk (when a spool directory is configured) and
+// replayed once the destination recovers.
+type logShipper struct {
+	sink          string
+	transport     logTransport
+	queue         chan logRecord
+	batchSize     int
+	flushInterval time.Duration
+	workerCount   int
+	maxRetries    int
+	spoolDir      string
+	spoolMaxBytes int64
+
+	// stopCtx is cancelled when shutdown runs out of time, so in-flight
+	// retries give up and spool instead.
+	stopCtx    context.Context
+	stopCancel context.CancelFunc
+
+	workers sync.WaitGroup
+	spoolMu sync.Mutex
+
+	mu       sync.RWMutex
+	isClosed bool
+	closed   chan struct{}
+}
+
+// logShipperOptions tunes a shipper for its transport. Local transports
+// (files, syslog) use a single worker and no retries or spool.
+type logShipperOptions struct {
+	batchSize int
+	workers   int
+	retries   int
+	spool     bool
+}
+
+func newLogShipper(sink string, transport logTransport, cfg logShipConfig, opts logShipperOptions) *logShipper {
+	batchSize := opts.batchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	queueSize := cfg.QueueSize
+	if queueSize < batchSize {
+		queueSize = batchSize
+	}
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	spoolDir := ""
+	if opts.spool && cfg.SpoolDir != "" {
+		spoolDir = filepath.Join(cfg.SpoolDir, sink)
+	}
+
+	stopCtx, stopCancel := context.WithCancel(context.Background())
+	return &logShipper{
+		sink:          sink,
+		transport:     transport,
+		queue:         make(chan logRecord, queueSize),
+		batchSize:     batchSize,
+		flushInterval: cfg.FlushInterval,
+		workerCount:   workers,
+		maxRetries:    opts.retries,
+		spoolDir:      spoolDir,
+		spoolMaxBytes: cfg.SpoolMaxBytes,
+		stopCtx:       stopCtx,
+		stopCancel:    stopCancel,
+		closed:        make(chan struct{}),
+	}
+}
+
+func (s *logShipper) start() {
+	for i := 0; i < s.workerCount; i++ {
+		s.workers.Add(1)
+		go s.run()
+	}
+
+	if s.spoolDir != "" {
+		if err := os.MkdirAll(s.spoolDir, 0o700); err != nil {
+			fmt.Fprintf(os.Stderr, "log spool directory unavailable for %s sink: %v\n", s.sink, err)
+			s.spoolDir = ""
+		} else {
+			go s.replayLoop()
+		}
+	}
+}
+
+// enqueue queues a record without blocking; it is dropped if the queue is full.
+func (s *logShipper) enqueue(record logRecord) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.isClosed {
+		logDroppedTotal.inc(s.sink, "shutdown")
+		return
+	}
+
+	select {
+	case s.queue <- record:
+	default:
+		logDroppedTotal.inc(s.sink, "queue_full")
+	}
+}
+
+func (s *logShipper) run() {
+	defer s.workers.Done()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]logRecord, 0, s.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			s.deliver(batch)
+			batch = make([]logRecord, 0, s.batchSize)
+		}
+	}
+
+	for {
+		select {
+		case record, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// deliver sends a batch, spooling it if every attempt fails.
+func (s *logShipper) deliver(batch []logRecord) {
+	err := s.sendWithRetry(batch)
+	if err == nil {
+		logShippedTotal.add(float64(len(batch)), s.sink)
+		return
+	}
+
+	if _, permanent := err.(*logSinkStatusError); permanent || s.spoolDir == "" {
+		logDroppedTotal.add(float64(len(batch)), s.sink, "request")
+		return
+	}
+	s.spool(batch)
+}
+
+// logSinkStatusError is a response the destination will keep rejecting.
+type logSinkStatusError struct {
+	status string
+}
+
+func (e *logSinkStatusError) Error() string {
+	return "log sink rejected batch: " + e.status
+}
+
+func (s *logShipper) sendWithRetry(batch []logRecord) error {
+	var err error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		if attempt > 0 {
+			ceiling := logShipRetryBase << uint(attempt-1)
+			if ceiling > logShipRetryMaxDelay || ceiling <= 0 {
+				ceiling = logShipRetryMaxDelay
+			}
+			if sleepContext(s.stopCtx, time.Duration(rand.Int63n(int64(ceiling)+1))) != nil {
+				return err
+			}
+		}
+
+		err = s.transport.send(s.stopCtx, batch)
+		if err == nil {
+			return nil
+		}
+		if _, permanent := err.(*logSinkStatusError); permanent {
+			return err
+		}
+	}
+	return err
+}
+
+// spool writes an undeliverable batch to disk for later replay.
+func (s *logShipper) spool(batch []logRecord) {
+	s.spoolMu.Lock()
+	defer s.spoolMu.Unlock()
+
+	entries := len(batch)
+	body, err := json.Marshal(batch)
+	if err != nil {
+		logDroppedTotal.add(float64(entries), s.sink, "spool_error")
+		return
+	}
+
+	if spoolSize(s.spoolDir)+int64(len(body)) > s.spoolMaxBytes {
+		logDroppedTotal.add(float64(entries), s.sink, "spool_full")
+		return
+	}
+
+	name := fmt.Sprintf("%020d-%04d.json", time.Now().UnixNano(), rand.Intn(10000))
+	tmp := filepath.Join(s.spoolDir, name+".tmp")
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		logDroppedTotal.add(float64(entries), s.sink, "spool_error")
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(s.spoolDir, name)); err != nil {
+		os.Remove(tmp)
+		logDroppedTotal.add(float64(entries), s.sink, "spool_error")
+		return
+	}
+	logSpooledTotal.add(float64(entries), s.sink)
+}
+
+// replayLoop replays anything left over from a previous run, then retries
+// the spool periodically.
+func (s *logShipper) replayLoop() {
+	s.replaySpool()
+
+	ticker := time.NewTicker(logShipReplayEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.replaySpool()
+		}
+	}
+}
+
+// replaySpool resends spooled batches oldest first, stopping at the first
+// failure so a destination that is still down is only probed once per pass.
+func (s *logShipper) replaySpool() {
+	for _, path := range spoolFiles(s.spoolDir) {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var batch []logRecord
+		if err := json.Unmarshal(body, &batch); err != nil {
+			os.Remove(path)
+			continue
+		}
+		err = s.transport.send(s.stopCtx, batch)
+		if _, permanent := err.(*logSinkStatusError); err != nil && !permanent {
+			return
+		}
+		if err == nil {
+			logShippedTotal.add(float64(len(batch)), s.sink)
+		} else {
+			logDroppedTotal.add(float64(len(batch)), s.sink, "request")
+		}
+		os.Remove(path)
+	}
+}
+
+func spoolFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func spoolSize(dir string) int64 {
+	var total int64
+	for _, path := range spoolFiles(dir) {
+		if info, err := os.Stat(path); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// close stops accepting entries and flushes what is queued. If ctx expires
+// first, in-flight retries are abandoned and their batches spooled.
+func (s *logShipper) close(ctx context.Context) {
+	s.mu.Lock()
+	if !s.isClosed {
+		s.isClosed = true
+		close(s.closed)
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.stopCancel()
+		<-done
+	}
+	s.stopCancel()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeLogTransport fails every send with err.
+type fakeLogTransport struct {
+	err error
+}
+
+func (t *fakeLogTransport) send(ctx context.Context, batch []logRecord) error {
+	return t.err
+}
+
+func counterValue(c *counterVec, labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[metricKey(labelValues)]
+}
+
+func TestReplaySpool(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantShipped float64
+		wantDropped float64
+		wantKept    int
+	}{
+		{"delivered", nil, 3, 0, 0},
+		{"rejected for good", &logSinkStatusError{status: "400 Bad Request"}, 0, 3, 0},
+		{"destination still down", context.DeadlineExceeded, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			body, _ := json.Marshal([]logRecord{{Line: "a"}, {Line: "b"}, {Line: "c"}})
+			if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-0001.json"), body, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			sink := "test-" + tt.name
+			s := &logShipper{sink: sink, transport: &fakeLogTransport{err: tt.err}, spoolDir: dir, stopCtx: context.Background()}
+			s.replaySpool()
+
+			if got := counterValue(logShippedTotal, sink); got != tt.wantShipped {
+				t.Errorf("shipped %v, want %v", got, tt.wantShipped)
+			}
+			if got := counterValue(logDroppedTotal, sink, "request"); got != tt.wantDropped {
+				t.Errorf("dropped %v, want %v", got, tt.wantDropped)
+			}
+			if got := len(spoolFiles(dir)); got != tt.wantKept {
+				t.Errorf("%d spool files left, want %d", got, tt.wantKept)
+			}
+		})
+	}
+}
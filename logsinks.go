@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Paths left out of every sink unless LOG_<SINK>_EXCLUDE_PATHS says otherwise.
+// Static assets are served on every page load and only add noise.
+const defaultLogExcludePaths = "*.js,*.css,*.png,*.jpg,*.jpeg,*.gif,*.ico,*.svg,*.woff,*.woff2,*.ttf,*.eot,*.map"
+
+// Used by LOG_<SINK>_FORMAT=text when no template is given
+const defaultTextLogTemplate = `{{.Timestamp}} {{upper .Level}} {{.Message}}` +
+	`{{with .RequestID}} requestId={{.}}{{end}}{{with .Path}} path={{.}}{{end}}` +
+	`{{with .StatusCode}} status={{.}}{{end}}{{with .Error}} error={{json .}}{{end}}`
+
+// Slack messages use mrkdwn and stay short; the full entry is on stdout.
+const defaultSlackLogTemplate = `{{levelEmoji .Level}} *{{upper .Level}}* {{.Message}}` +
+	`{{with .Error}}` + "\n```{{.}}```" + `{{end}}` +
+	`{{with .RequestID}}` + "\n_request {{.}}_" + `{{end}}`
+
+// logSink is one log destination with its own level, path filter and
+// payload template. Formatted records are delivered by a logShipper so a
+// slow destination never blocks the request that logged.
+type logSink struct {
+	name     string
+	minLevel int
+	exclude  []string
+	template *template.Template // nil writes the entry as JSON
+	shipper  *logShipper
+	closer   io.Closer
+}
+
+var logTemplateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"levelEmoji": func(level string) string {
+		switch level {
+		case "error":
+			return "🔴"
+		case "warn":
+			return "🟠"
+		case "debug":
+			return "⚪"
+		default:
+			return "🔵"
+		}
+	},
+}
+
+// logSinksConfig says where logs are shipped besides stdout. A sink is on
+// when its destination is set.
+type logSinksConfig struct {
+	WebhookURL string
+	// Ship all logs, not just errors. Predates per-sink levels and still
+	// sets the webhook's default level.
+	WebhookAll bool
+
+	SlackWebhookURL string
+
+	LokiURL      string
+	LokiLabels   string // key=value pairs, comma-separated
+	LokiUsername string
+	LokiPassword string
+	LokiTenantID string
+
+	SyslogAddr string
+	SyslogTag  string
+
+	FilePath       string
+	FileMaxBytes   int64
+	FileMaxBackups int
+
+	Shipping logShipConfig
+	// Per-sink settings, by sink name
+	Sink map[string]logSinkConfig
+}
+
+// logSinkConfig is the LOG_<SINK>_LEVEL, _EXCLUDE_PATHS, _FORMAT, _TEMPLATE
+// and _BATCH_SIZE settings of one sink.
+type logSinkConfig struct {
+	Level        string
+	ExcludePaths string
+	Format       string
+	Template     string
+	BatchSize    int
+}
+
+func loadLogSinksConfig(src *configSource) logSinksConfig {
+	cfg := logSinksConfig{
+		WebhookURL:      src.getEnv("LOG_WEBHOOK_URL", ""),
+		WebhookAll:      src.getEnvBool("LOG_WEBHOOK_ALL", true),
+		SlackWebhookURL: src.getEnv("LOG_SLACK_WEBHOOK_URL", ""),
+		LokiURL:         src.getEnv("LOG_LOKI_URL", ""),
+		LokiLabels:      src.getEnv("LOG_LOKI_LABELS", ""),
+		LokiUsername:    src.getEnv("LOG_LOKI_USERNAME", ""),
+		LokiPassword:    src.getEnv("LOG_LOKI_PASSWORD", ""),
+		LokiTenantID:    src.getEnv("LOG_LOKI_TENANT_ID", ""),
+		SyslogAddr:      src.getEnv("LOG_SYSLOG_ADDR", ""),
+		SyslogTag:       src.getEnv("LOG_SYSLOG_TAG", "yabt"),
+		FilePath:        src.getEnv("LOG_FILE_PATH", ""),
+		FileMaxBytes:    int64(src.getEnvInt("LOG_FILE_MAX_MB", 100)) << 20,
+		FileMaxBackups:  src.getEnvInt("LOG_FILE_MAX_BACKUPS", 5),
+		Shipping:        loadLogShipConfig(src),
+		Sink:            map[string]logSinkConfig{},
+	}
+
+	webhookLevel := "warn"
+	if cfg.WebhookAll {
+		webhookLevel = "debug"
+	}
+	defaults := []struct {
+		name      string
+		level     string
+		batchSize int // 0 when the batch size is fixed
+	}{
+		{"webhook", webhookLevel, 1},
+		{"slack", "warn", 1},
+		{"loki", "info", 100},
+		{"syslog", "info", 0},
+		{"file", "info", 0},
+	}
+	for _, d := range defaults {
+		prefix := "LOG_" + strings.ToUpper(d.name) + "_"
+		sink := logSinkConfig{
+			Level:        src.getEnvEnum(prefix+"LEVEL", d.level, "debug", "info", "warn", "error"),
+			ExcludePaths: src.getEnv(prefix+"EXCLUDE_PATHS", defaultLogExcludePaths),
+			Format:       src.getEnvEnum(prefix+"FORMAT", "", "json", "text"),
+			Template:     src.getEnv(prefix+"TEMPLATE", ""),
+		}
+		if d.batchSize > 0 {
+			sink.BatchSize = src.getEnvInt(prefix+"BATCH_SIZE", d.batchSize)
+		}
+		cfg.Sink[d.name] = sink
+	}
+	return cfg
+}
+
+// newLogSinks builds every sink whose destination is configured. A sink
+// that cannot be set up is skipped and reported, without affecting others.
+func newLogSinks(cfg logSinksConfig, environment string) ([]*logSink, []error) {
+	var sinks []*logSink
+	var errs []error
+
+	add := func(name string, sink *logSink, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s log sink: %w", name, err))
+			return
+		}
+		sinks = append(sinks, sink)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	shipping := cfg.Shipping
+
+	if cfg.WebhookURL != "" {
+		sink, err := newLogSink("webhook", cfg.Sink["webhook"], "",
+			&webhookTransport{url: cfg.WebhookURL, client: client}, shipping,
+			logShipperOptions{batchSize: cfg.Sink["webhook"].BatchSize, workers: shipping.Workers, retries: shipping.MaxRetries, spool: true})
+		add("webhook", sink, err)
+	}
+
+	if cfg.SlackWebhookURL != "" {
+		sink, err := newLogSink("slack", cfg.Sink["slack"], defaultSlackLogTemplate,
+			&slackTransport{url: cfg.SlackWebhookURL, client: client}, shipping,
+			logShipperOptions{batchSize: cfg.Sink["slack"].BatchSize, workers: 1, retries: shipping.MaxRetries, spool: true})
+		add("slack", sink, err)
+	}
+
+	if cfg.LokiURL != "" {
+		transport, err := newLokiTransport(cfg, environment, client)
+		var sink *logSink
+		if err == nil {
+			sink, err = newLogSink("loki", cfg.Sink["loki"], "", transport, shipping,
+				logShipperOptions{batchSize: cfg.Sink["loki"].BatchSize, workers: shipping.Workers, retries: shipping.MaxRetries, spool: true})
+		}
+		add("loki", sink, err)
+	}
+
+	if cfg.SyslogAddr != "" {
+		writer, err := dialSyslog(cfg.SyslogAddr, cfg.SyslogTag)
+		var sink *logSink
+		if err == nil {
+			if sink, err = newLogSink("syslog", cfg.Sink["syslog"], "", &syslogTransport{writer: writer}, shipping,
+				logShipperOptions{batchSize: 1, workers: 1}); err == nil {
+				sink.closer = writer
+			} else {
+				writer.Close()
+			}
+		}
+		add("syslog", sink, err)
+	}
+
+	if cfg.FilePath != "" {
+		file, err := openRotatingFile(cfg.FilePath, cfg.FileMaxBytes, cfg.FileMaxBackups)
+		var sink *logSink
+		if err == nil {
+			if sink, err = newLogSink("file", cfg.Sink["file"], "", &fileTransport{file: file}, shipping,
+				logShipperOptions{batchSize: 50, workers: 1}); err == nil {
+				sink.closer = file
+			} else {
+				file.Close()
+			}
+		}
+		add("file", sink, err)
+	}
+
+	return sinks, errs
+}
+
+// newLogSink applies a sink's level, path filter, format and template.
+func newLogSink(name string, cfg logSinkConfig, defaultTemplate string, transport logTransport, shipping logShipConfig, opts logShipperOptions) (*logSink, error) {
+	sink := &logSink{name: name}
+
+	minLevel, ok := logLevels[cfg.Level]
+	if !ok {
+		return nil, fmt.Errorf("unknown level %q", cfg.Level)
+	}
+	sink.minLevel = minLevel
+
+	for _, pattern := range strings.Split(cfg.ExcludePaths, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			sink.exclude = append(sink.exclude, pattern)
+		}
+	}
+
+	text := cfg.Template
+	if text == "" {
+		switch cfg.Format {
+		case "text":
+			text = defaultTextLogTemplate
+		case "json":
+		case "":
+			text = defaultTemplate
+		default:
+			return nil, fmt.Errorf("unknown format %q", cfg.Format)
+		}
+	}
+	if text != "" {
+		tmpl, err := template.New(name).Funcs(logTemplateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		sink.template = tmpl
+	}
+
+	sink.shipper = newLogShipper(name, transport, shipping, opts)
+	sink.shipper.start()
+	return sink, nil
+}
+
+// accepts reports whether the sink wants an entry at this level and path.
+func (s *logSink) accepts(entry *LogEntry) bool {
+	level, ok := logLevels[entry.Level]
+	if !ok || level > s.minLevel {
+		return false
+	}
+	if entry.Path == "" {
+		return true
+	}
+	for _, pattern := range s.exclude {
+		if matchLogPath(pattern, entry.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchLogPath matches "*.ext" against the suffix, "/prefix/*" against any
+// path below the prefix, and anything else with path.Match.
+func matchLogPath(pattern, requestPath string) bool {
+	switch {
+	case strings.HasPrefix(pattern, "*") && !strings.ContainsAny(pattern[1:], "*?["):
+		return strings.HasSuffix(requestPath, pattern[1:])
+	case strings.HasSuffix(pattern, "/*") && !strings.ContainsAny(pattern[:len(pattern)-1], "*?["):
+		return strings.HasPrefix(requestPath, pattern[:len(pattern)-1])
+	}
+	matched, _ := path.Match(pattern, requestPath)
+	return matched
+}
+
+func (s *logSink) format(entry *LogEntry) (string, error) {
+	if s.template == nil {
+		b, err := json.Marshal(entry)
+		return string(b), err
+	}
+	var buf bytes.Buffer
+	if err := s.template.Execute(&buf, entry); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// shipToSinks queues an entry for every sink that accepts it.
+func shipToSinks(sinks []*logSink, entry *LogEntry) {
+	for _, sink := range sinks {
+		if !sink.accepts(entry) {
+			continue
+		}
+		line, err := sink.format(entry)
+		if err != nil {
+			logDroppedTotal.inc(sink.name, "format")
+			continue
+		}
+		sink.shipper.enqueue(logRecord{Level: entry.Level, Time: time.Now(), Line: line})
+	}
+}
+
+// registerLogQueueGauge reports the entries waiting in the sinks' queues
+// on /metrics. serveCommand registers it once the sinks have started.
+func registerLogQueueGauge(sinks []*logSink) {
+	newGaugeFunc("yabt_log_queue_length", "Log entries waiting to be shipped across all sinks.",
+		nil, func() ([]string, float64) {
+			queued := 0
+			for _, sink := range sinks {
+				queued += len(sink.shipper.queue)
+			}
+			return nil, float64(queued)
+		})
+}
+
+// closeLogSinks flushes every sink and releases its destination.
+func closeLogSinks(ctx context.Context, sinks []*logSink) {
+	var wg sync.WaitGroup
+	for _, sink := range sinks {
+		wg.Add(1)
+		go func(sink *logSink) {
+			defer wg.Done()
+			sink.shipper.close(ctx)
+			if sink.closer != nil {
+				sink.closer.Close()
+			}
+		}(sink)
+	}
+	wg.Wait()
+}
+
+func logSinkNames(sinks []*logSink) string {
+	if len(sinks) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(sinks))
+	for _, sink := range sinks {
+		for level, value := range logLevels {
+			if value == sink.minLevel {
+				names = append(names, fmt.Sprintf("%s (%s)", sink.name, level))
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// postLogBody sends a JSON body and classifies the response: 429 and 5xx
+// are retried, other failures are permanent.
+func postLogBody(ctx context.Context, client *http.Client, req *http.Request) error {
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("log sink returned %s", resp.Status)
+	default:
+		return &logSinkStatusError{status: resp.Status}
+	}
+}
+
+// webhookTransport posts entries to a generic JSON webhook. A batch of one
+// is posted as a single object, which is what the bundled n8n workflow
+// expects; larger batches are posted as a JSON array.
+type webhookTransport struct {
+	url    string
+	client *http.Client
+}
+
+func (t *webhookTransport) send(ctx context.Context, batch []logRecord) error {
+	var body string
+	if len(batch) == 1 {
+		body = batch[0].Line
+	} else {
+		lines := make([]string, len(batch))
+		for i, record := range batch {
+			lines[i] = record.Line
+		}
+		body = "[" + strings.Join(lines, ",") + "]"
+	}
+
+	req, err := http.NewRequest("POST", t.url, strings.NewReader(body))
+	if err != nil {
+		return &logSinkStatusError{status: err.Error()}
+	}
+	return postLogBody(ctx, t.client, req)
+}
+
+// slackTransport posts to a Slack incoming webhook, one message per batch.
+type slackTransport struct {
+	url    string
+	client *http.Client
+}
+
+func (t *slackTransport) send(ctx context.Context, batch []logRecord) error {
+	lines := make([]string, len(batch))
+	for i, record := range batch {
+		lines[i] = record.Line
+	}
+	body, err := json.Marshal(map[string]string{"text": strings.Join(lines, "\n")})
+	if err != nil {
+		return &logSinkStatusError{status: err.Error()}
+	}
+
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return &logSinkStatusError{status: err.Error()}
+	}
+	return postLogBody(ctx, t.client, req)
+}
+
+// lokiTransport pushes to the Loki push API, one stream per level.
+type lokiTransport struct {
+	url      string
+	client   *http.Client
+	labels   map[string]string
+	username string
+	password string
+	tenant   string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiTransport(cfg logSinksConfig, environment string, client *http.Client) (*lokiTransport, error) {
+	u, err := url.Parse(cfg.LokiURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid LOG_LOKI_URL %q", cfg.LokiURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/loki/api/v1/push"
+	}
+
+	labels := map[string]string{"service": "yabt", "environment": environment}
+	for _, pair := range strings.Split(cfg.LokiLabels, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && key != "" {
+			labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return &lokiTransport{
+		url:      u.String(),
+		client:   client,
+		labels:   labels,
+		username: cfg.LokiUsername,
+		password: cfg.LokiPassword,
+		tenant:   cfg.LokiTenantID,
+	}, nil
+}
+
+func (t *lokiTransport) send(ctx context.Context, batch []logRecord) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for _, record := range batch {
+		stream, ok := streams[record.Level]
+		if !ok {
+			labels := make(map[string]string, len(t.labels)+1)
+			for key, value := range t.labels {
+				labels[key] = value
+			}
+			labels["level"] = record.Level
+			stream = &lokiStream{Stream: labels}
+			streams[record.Level] = stream
+			order = append(order, record.Level)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(record.Time.UnixNano(), 10), record.Line})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, level := range order {
+		payload.Streams = append(payload.Streams, streams[level])
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &logSinkStatusError{status: err.Error()}
+	}
+
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return &logSinkStatusError{status: err.Error()}
+	}
+	if t.username != "" || t.password != "" {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	if t.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", t.tenant)
+	}
+	return postLogBody(ctx, t.client, req)
+}
+
+// dialSyslog connects to "local" (the system logger), or to a remote
+// daemon given as udp://host:port or tcp://host:port.
+func dialSyslog(addr, tag string) (*syslog.Writer, error) {
+	if addr == "local" {
+		return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	}
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("invalid LOG_SYSLOG_ADDR %q (want local, udp://host:port or tcp://host:port)", addr)
+	}
+	return syslog.Dial(u.Scheme, u.Host, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
+
+// syslogTransport writes each record with the severity matching its level.
+type syslogTransport struct {
+	writer *syslog.Writer
+}
+
+func (t *syslogTransport) send(ctx context.Context, batch []logRecord) error {
+	for _, record := range batch {
+		var err error
+		switch record.Level {
+		case "error":
+			err = t.writer.Err(record.Line)
+		case "warn":
+			err = t.writer.Warning(record.Line)
+		case "debug":
+			err = t.writer.Debug(record.Line)
+		default:
+			err = t.writer.Info(record.Line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileTransport appends records to a local file, one per line.
+type fileTransport struct {
+	file *rotatingFile
+}
+
+func (t *fileTransport) send(ctx context.Context, batch []logRecord) error {
+	var buf bytes.Buffer
+	for _, record := range batch {
+		buf.WriteString(record.Line)
+		buf.WriteByte('\n')
+	}
+	return t.file.write(buf.Bytes())
+}
+
+// rotatingFile is an append-only file that is rotated to path.1, path.2, ...
+// once it reaches maxBytes, keeping at most `backups` old files.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	backups  int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(filePath string, maxBytes int64, backups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{path: filePath, maxBytes: maxBytes, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) write(p []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return errors.New("log file is closed")
+	}
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.backups))
+		for i := f.backups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(f.path, 0); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -2,19 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"math"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -23,21 +24,88 @@ import (
 	"github.com/google/uuid"
 )
 
-// Configuration from environment
-var (
-	port           = getEnv("PORT", "5177")
-	logLevel       = getEnv("LOG_LEVEL", "info")
-	logWebhookURL  = getEnv("LOG_WEBHOOK_URL", "")
-	logWebhookAll  = getEnv("LOG_WEBHOOK_ALL", "true") == "true" // Ship all logs, not just errors
-	logRequestBody = getEnv("LOG_REQUEST_BODY", "true") == "true"
-	nodeEnv        = getEnv("NODE_ENV", "production")
-	ollamaAPIKey   = getEnv("OLLAMA_API_KEY", "")
-	ollamaModel    = getEnv("OLLAMA_MODEL", "gpt-oss:20b-cloud")
-	groqAPIKey     = getEnv("GROQ_API_KEY", "")
-	supabaseURL    = getEnv("SUPABASE_URL", getEnv("PUBLIC_SUPABASE_URL", getEnv("VITE_SUPABASE_URL", "")))
-	supabaseKey    = getEnv("SUPABASE_SERVICE_ROLE_KEY", "")
-	distPath       = "./dist"
-)
+// app holds the configuration and per-process state the HTTP handlers
+// share. serveCommand builds one from the loaded Config.
+type app struct {
+	cfg       *Config
+	features  []*feature
+	redaction *redactionPolicy
+	lifecycle *lifecycle
+	readiness *readinessState
+
+	// Storage backend, nil with the reason in storeErr when unavailable
+	store    dataStore
+	storeErr error
+	// The built SPA being served
+	frontend fs.FS
+
+	logAPILimiter *rateLimiter
+	// Requests per client IP, checked before any session is verified so
+	// junk tokens can't be used to flood the Supabase Auth API
+	logAPIIPLimiter  *rateLimiter
+	cspReportLimiter *rateLimiter
+}
+
+func newApp(cfg *Config, lc *lifecycle, store dataStore, storeErr error, frontend fs.FS) *app {
+	return &app{
+		cfg:              cfg,
+		features:         newFeatureRegistry(cfg, store),
+		redaction:        newRedactionPolicy(cfg.Log.Redact),
+		lifecycle:        lc,
+		readiness:        newReadinessState(),
+		store:            store,
+		storeErr:         storeErr,
+		frontend:         frontend,
+		logAPILimiter:    newRateLimiter(cfg.LogAPI.RateLimit, cfg.LogAPI.RateBurst),
+		logAPIIPLimiter:  newRateLimiter(cfg.LogAPI.IPRateLimit, cfg.LogAPI.IPRateBurst),
+		cspReportLimiter: newRateLimiter(cfg.Security.CSPReportRateLimit, 30),
+	}
+}
+
+// aiConfig holds the AI providers: Ollama for chat and parsing, Groq for
+// transcription.
+type aiConfig struct {
+	OllamaAPIKey string
+	OllamaModel  string
+	GroqAPIKey   string
+}
+
+func loadAIConfig(src *configSource) aiConfig {
+	return aiConfig{
+		OllamaAPIKey: src.getEnv("OLLAMA_API_KEY", ""),
+		OllamaModel:  src.getEnv("OLLAMA_MODEL", "gpt-oss:20b-cloud"),
+		GroqAPIKey:   src.getEnv("GROQ_API_KEY", ""),
+	}
+}
+
+// logAPIConfig limits what browsers may send to /api/log.
+type logAPIConfig struct {
+	MaxBytes    int
+	MaxBatch    int
+	RequireAuth bool
+	RateLimit   int
+	RateBurst   int
+	IPRateLimit int
+	IPRateBurst int
+}
+
+func loadLogAPIConfig(src *configSource) logAPIConfig {
+	return logAPIConfig{
+		MaxBytes:    src.getEnvInt("LOG_API_MAX_BYTES", 64<<10),
+		MaxBatch:    src.getEnvInt("LOG_API_MAX_BATCH", 50),
+		RequireAuth: src.getEnvBool("LOG_API_REQUIRE_AUTH", false),
+		RateLimit:   src.getEnvInt("LOG_API_RATE_LIMIT", 120),
+		RateBurst:   src.getEnvInt("LOG_API_RATE_BURST", 60),
+		IPRateLimit: src.getEnvInt("LOG_API_IP_RATE_LIMIT", 60),
+		IPRateBurst: src.getEnvInt("LOG_API_IP_RATE_BURST", 30),
+	}
+}
+
+// Longest client log message kept
+const logAPIMaxMessage = 2000
+
+// Request bodies larger than this are not buffered for logging
+const maxLoggedBodyBytes = 64 << 10
 
 // Log levels
 var logLevels = map[string]int{
@@ -55,6 +123,8 @@ type LogEntry struct {
 	Service      string      `json:"service"`
 	Environment  string      `json:"environment,omitempty"`
 	RequestID    string      `json:"requestId,omitempty"`
+	TraceID      string      `json:"traceId,omitempty"`
+	Route        string      `json:"route,omitempty"`
 	Method       string      `json:"method,omitempty"`
 	Path         string      `json:"path,omitempty"`
 	StatusCode   int         `json:"statusCode,omitempty"`
@@ -65,6 +135,9 @@ type LogEntry struct {
 	Body         interface{} `json:"body,omitempty"`
 	User         string      `json:"user,omitempty"`
 	Error        string      `json:"error,omitempty"`
+
+	// Fields holds any other attributes logged with the entry
+	Fields map[string]interface{} `json:"-"`
 }
 
 // HealthResponse represents the health check response
@@ -73,6 +146,7 @@ type HealthResponse struct {
 	Timestamp   string `json:"timestamp"`
 	Uptime      string `json:"uptime"`
 	Version     string `json:"version"`
+	Commit      string `json:"commit"`
 	Environment string `json:"environment"`
 }
 
@@ -93,21 +167,23 @@ type parsedTransaction struct {
 }
 
 type shortcutResponse struct {
-	Success        bool             `json:"success"`
-	Message        string           `json:"message,omitempty"`
-	TransactionID  string           `json:"transaction_id,omitempty"`
-	Amount         float64          `json:"amount,omitempty"`
-	Payee          string           `json:"payee,omitempty"`
-	Category       string           `json:"category,omitempty"`
-	Account        string           `json:"account,omitempty"`
-	Date           string           `json:"date,omitempty"`
-	Parsed         *parsedTransaction `json:"parsed,omitempty"`
+	Success       bool               `json:"success"`
+	Message       string             `json:"message,omitempty"`
+	TransactionID string             `json:"transaction_id,omitempty"`
+	Amount        float64            `json:"amount,omitempty"`
+	Payee         string             `json:"payee,omitempty"`
+	Category      string             `json:"category,omitempty"`
+	Account       string             `json:"account,omitempty"`
+	Date          string             `json:"date,omitempty"`
+	Parsed        *parsedTransaction `json:"parsed,omitempty"`
 }
 
 type supabaseClient struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
+	maxRetries int
+	breaker    *circuitBreaker
 }
 
 type apiKeyRecord struct {
@@ -120,6 +196,12 @@ type apiKeyRecord struct {
 	CreatedAt  string  `json:"created_at"`
 }
 
+type budgetRecord struct {
+	ID       string          `json:"id"`
+	UserID   string          `json:"user_id"`
+	Features map[string]bool `json:"features"`
+}
+
 type accountRecord struct {
 	ID          string  `json:"id"`
 	BudgetID    string  `json:"budget_id"`
@@ -156,175 +238,147 @@ type transactionRecord struct {
 
 var startTime = time.Now()
 
-// Sensitive fields to redact
-var sensitiveFields = []string{"password", "token", "apiKey", "secret", "authorization", "access_token", "refresh_token"}
+// Build metadata, injected at build time with
+// -ldflags "-X main.version=<version> -X main.commit=<git sha>"
+var (
+	version = "dev"
+	commit  = ""
+)
 
+// Sensitive fields to redact
 var jsonObjectRegex = regexp.MustCompile(`\{[\s\S]*\}`)
 var matchSanitizerRegex = regexp.MustCompile(`[^a-z0-9\s]+`)
 var matchSpaceRegex = regexp.MustCompile(`\s+`)
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+// supabaseConfig is the Supabase project, used for storage, for verifying
+// sessions and by the browser.
+type supabaseConfig struct {
+	URL            string
+	ServiceRoleKey string
+	// Verifies session tokens locally instead of through the Auth API
+	JWTSecret string
+
+	MaxRetries       int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
 }
 
-func currentLogLevelValue() int {
-	if level, ok := logLevels[logLevel]; ok {
-		return level
+func loadSupabaseConfig(src *configSource) supabaseConfig {
+	return supabaseConfig{
+		URL:              src.getEnv("SUPABASE_URL", src.getEnv("PUBLIC_SUPABASE_URL", src.getEnv("VITE_SUPABASE_URL", ""))),
+		ServiceRoleKey:   src.getEnv("SUPABASE_SERVICE_ROLE_KEY", ""),
+		JWTSecret:        src.getEnv("SUPABASE_JWT_SECRET", ""),
+		MaxRetries:       src.getEnvInt("SUPABASE_MAX_RETRIES", 2),
+		BreakerThreshold: src.getEnvInt("SUPABASE_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  src.getEnvDuration("SUPABASE_BREAKER_COOLDOWN", 30*time.Second),
 	}
-	return logLevels["info"]
 }
 
-func logJSON(level, message string, entry *LogEntry) {
-	if logLevels[level] > currentLogLevelValue() {
-		return
-	}
-
-	entry.Timestamp = time.Now().UTC().Format(time.RFC3339)
-	entry.Level = level
-	entry.Level = level
-
-	// Format message with user if available
-	userPrefix := "[-]"
-	if entry.User != "" {
-		userPrefix = fmt.Sprintf("[%s]", entry.User)
+func newSupabaseClient(cfg supabaseConfig) *supabaseClient {
+	return &supabaseClient{
+		baseURL:    strings.TrimRight(cfg.URL, "/"),
+		apiKey:     cfg.ServiceRoleKey,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+		maxRetries: cfg.MaxRetries,
+		breaker:    newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
 	}
-	entry.Message = fmt.Sprintf("%s %s", userPrefix, message)
+}
 
-	entry.Service = "yabt"
-	entry.Environment = nodeEnv
+func (c *supabaseClient) request(ctx context.Context, method, path string, query url.Values, body interface{}, dest interface{}) error {
+	return c.requestWithPrefer(ctx, method, path, query, body, dest, "")
+}
 
-	jsonBytes, err := json.Marshal(entry)
-	if err != nil {
-		log.Printf("Error marshaling log entry: %v", err)
-		return
+// requestWithPrefer sends a PostgREST request. Idempotent requests are retried
+// on transient failures with jittered exponential backoff, and all requests
+// fail fast with errCircuitOpen while Supabase is considered down.
+func (c *supabaseClient) requestWithPrefer(ctx context.Context, method, path string, query url.Values, body interface{}, dest interface{}, prefer string) error {
+	urlStr := fmt.Sprintf("%s/rest/v1/%s", c.baseURL, path)
+	if query != nil && len(query) > 0 {
+		urlStr = urlStr + "?" + query.Encode()
 	}
 
-	fmt.Println(string(jsonBytes))
-
-	// Ship to webhook
-	if logWebhookURL != "" {
-		// Ship all logs if enabled, otherwise only errors and warnings
-		if logWebhookAll || level == "error" || level == "warn" {
-			// Skip static asset logs to reduce noise
-			if entry.Path == "" || !isStaticAsset(entry.Path) {
-				go shipToWebhook(entry)
-			}
+	var bodyBytes []byte
+	if body != nil {
+		jsonBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
 		}
+		bodyBytes = jsonBytes
 	}
-}
 
-func shipToWebhook(entry *LogEntry) {
-	if logWebhookURL == "" {
-		return
+	if prefer == "" && (method == http.MethodPost || method == http.MethodPatch) {
+		prefer = "return=representation"
 	}
 
-	jsonBytes, err := json.Marshal(entry)
-	if err != nil {
-		return
+	attempts := 1
+	if isIdempotentRequest(method, prefer) {
+		attempts += c.maxRetries
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	req, err := http.NewRequest("POST", logWebhookURL, bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
+	ctx, span := startSpan(ctx, "supabase "+method+" "+path, spanKindClient)
+	defer span.finish()
+	span.setAttr("http.request.method", method)
+	span.setAttr("db.system", "postgresql")
+	span.setAttr("db.sql.table", path)
 
-	_, _ = client.Do(req)
-}
-
-// isStaticAsset checks if the path is a static asset
-func isStaticAsset(path string) bool {
-	exts := []string{".js", ".css", ".png", ".jpg", ".jpeg", ".gif", ".ico", ".svg", ".woff", ".woff2", ".ttf", ".eot", ".map"}
-	for _, ext := range exts {
-		if strings.HasSuffix(path, ext) {
-			return true
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if !c.breaker.allow() {
+			if lastErr == nil {
+				lastErr = fmt.Errorf("supabase %s %s: %w", method, path, errCircuitOpen)
+			}
+			span.setError(lastErr)
+			return lastErr
 		}
-	}
-	return false
-}
 
-func redactSensitiveFields(data map[string]interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	for key, value := range data {
-		lowKey := strings.ToLower(key)
-		issSensitive := false
-		for _, field := range sensitiveFields {
-			if strings.Contains(lowKey, field) {
-				issSensitive = true
-				break
+		start := time.Now()
+		respBody, err := c.do(ctx, method, path, urlStr, bodyBytes, prefer)
+		observeOutbound("supabase", start, err == nil)
+		c.breaker.record(!countsAsOutage(err))
+		span.setAttr("yabt.attempts", attempt)
+		if err == nil {
+			if dest != nil && len(respBody) > 0 {
+				return json.Unmarshal(respBody, dest)
 			}
+			return nil
 		}
-		if issSensitive {
-			result[key] = "[REDACTED]"
-		} else if nested, ok := value.(map[string]interface{}); ok {
-			result[key] = redactSensitiveFields(nested)
-		} else {
-			result[key] = value
-		}
-	}
-	return result
-}
 
-// extractUserFromJWT attempts to extract email or sub from JWT token
-func extractUserFromJWT(tokenString string) string {
-	parts := strings.Split(tokenString, ".")
-	if len(parts) != 3 {
-		return ""
-	}
-
-	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return ""
-	}
-
-	var claims map[string]interface{}
-	if err := json.Unmarshal(payload, &claims); err != nil {
-		return ""
-	}
-
-	if email, ok := claims["email"].(string); ok {
-		return email
-	}
-	if sub, ok := claims["sub"].(string); ok {
-		return sub
-	}
-	return ""
-}
+		lastErr = err
+		if attempt == attempts || ctx.Err() != nil || !shouldRetrySupabase(err) {
+			break
+		}
 
-func newSupabaseClient(baseURL, apiKey string) *supabaseClient {
-	return &supabaseClient{
-		baseURL:    strings.TrimRight(baseURL, "/"),
-		apiKey:     apiKey,
-		httpClient: &http.Client{Timeout: 15 * time.Second},
+		delay := retryDelay(attempt, err)
+		if delay > supabaseRetryMaxDelay {
+			break
+		}
+		loggerFrom(ctx).WarnContext(ctx, "Supabase request failed, retrying",
+			"supabaseMethod", method,
+			"supabasePath", path,
+			"retryIn", delay.Round(time.Millisecond),
+			"attempt", attempt+1,
+			"attempts", attempts,
+			"error", err)
+		if err := sleepContext(ctx, delay); err != nil {
+			span.setError(lastErr)
+			return lastErr
+		}
 	}
-}
 
-func (c *supabaseClient) request(method, path string, query url.Values, body interface{}, dest interface{}) error {
-	return c.requestWithPrefer(method, path, query, body, dest, "")
+	span.setError(lastErr)
+	return lastErr
 }
 
-func (c *supabaseClient) requestWithPrefer(method, path string, query url.Values, body interface{}, dest interface{}, prefer string) error {
-	urlStr := fmt.Sprintf("%s/rest/v1/%s", c.baseURL, path)
-	if query != nil && len(query) > 0 {
-		urlStr = urlStr + "?" + query.Encode()
-	}
-
+// do performs a single attempt and returns the response body on success.
+func (c *supabaseClient) do(ctx context.Context, method, path, urlStr string, body []byte, prefer string) ([]byte, error) {
 	var bodyReader io.Reader
 	if body != nil {
-		jsonBytes, err := json.Marshal(body)
-		if err != nil {
-			return err
-		}
-		bodyReader = bytes.NewBuffer(jsonBytes)
+		bodyReader = bytes.NewReader(body)
 	}
 
-	req, err := http.NewRequest(method, urlStr, bodyReader)
+	req, err := newOutboundRequest(ctx, method, urlStr, bodyReader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("apikey", c.apiKey)
@@ -333,35 +387,26 @@ func (c *supabaseClient) requestWithPrefer(method, path string, query url.Values
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
-
 	if prefer != "" {
 		req.Header.Set("Prefer", prefer)
-	} else if method == http.MethodPost || method == http.MethodPatch {
-		req.Header.Set("Prefer", "return=representation")
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("supabase %s %s failed: %s", method, path, string(respBody))
+		return nil, newSupabaseError(method, path, resp, respBody)
 	}
 
-	if dest != nil && len(respBody) > 0 {
-		if err := json.Unmarshal(respBody, dest); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return respBody, nil
 }
 
 func getAPIKeyFromRequest(r *http.Request) string {
@@ -583,8 +628,16 @@ func matchCategory(categories []categoryRecord, target string) *categoryRecord {
 	return best
 }
 
-func parseAITransaction(text string) (parsedTransaction, error) {
-	if ollamaAPIKey == "" {
+func parseAITransaction(ctx context.Context, ai aiConfig, text string) (parsed parsedTransaction, err error) {
+	ctx, span := startSpan(ctx, "ai.parse", spanKindClient)
+	span.setAttr("gen_ai.system", "ollama")
+	span.setAttr("gen_ai.request.model", ai.OllamaModel)
+	defer func() {
+		span.setError(err)
+		span.finish()
+	}()
+
+	if ai.OllamaAPIKey == "" {
 		return parsedTransaction{}, fmt.Errorf("ollama API key not configured")
 	}
 
@@ -613,7 +666,7 @@ Rules:
 - If any field cannot be determined, use null`, text)
 
 	body := map[string]interface{}{
-		"model": ollamaModel,
+		"model": ai.OllamaModel,
 		"messages": []map[string]string{{
 			"role":    "user",
 			"content": prompt,
@@ -630,21 +683,24 @@ Rules:
 		return parsedTransaction{}, err
 	}
 
-	req, err := http.NewRequest("POST", "https://ollama.com/api/chat", bytes.NewBuffer(jsonBytes))
+	req, err := newOutboundRequest(ctx, "POST", "https://ollama.com/api/chat", bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		return parsedTransaction{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+ollamaAPIKey)
+	req.Header.Set("Authorization", "Bearer "+ai.OllamaAPIKey)
 
 	client := &http.Client{Timeout: 60 * time.Second}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		observeOutbound("ollama", start, false)
 		return parsedTransaction{}, fmt.Errorf("ollama API request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
+	observeOutbound("ollama", start, err == nil && resp.StatusCode < 300)
 	if err != nil {
 		return parsedTransaction{}, err
 	}
@@ -713,6 +769,16 @@ func writeJSONError(w http.ResponseWriter, status int, message string) {
 	})
 }
 
+// writeStoreError reports a storage failure, returning 503 instead of 500
+// while the backend is failing fast so clients know to retry later.
+func writeStoreError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, errCircuitOpen) {
+		writeJSONError(w, http.StatusServiceUnavailable, "Storage temporarily unavailable, please retry shortly")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, message)
+}
+
 // ResponseWriter wrapper to capture status code and response body
 type responseWriter struct {
 	http.ResponseWriter
@@ -739,7 +805,7 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 }
 
 // Logging middleware
-func loggingMiddleware(next http.Handler) http.Handler {
+func (a *app) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		startTime := time.Now()
 
@@ -750,52 +816,65 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		}
 		w.Header().Set("X-Request-ID", requestID)
 
-		// Get client IP
-		ip := r.Header.Get("X-Forwarded-For")
-		if ip == "" {
-			ip = r.Header.Get("X-Real-IP")
-		}
-		if ip == "" {
-			ip = r.RemoteAddr
+		// Start the server span, continuing the caller's trace if any
+		route := routeLabel(next, r)
+		method := methodLabel(r.Method)
+		ip := resolveClientIP(r, a.cfg.TrustedProxies, a.cfg.TrustedProxyHeader)
+		ctx := withClientIP(withRequestID(r.Context(), requestID), ip)
+		ctx = extractTraceparent(ctx, r.Header)
+		ctx, span := startSpan(ctx, method+" "+route, spanKindServer)
+		span.setAttr("http.request.method", method)
+		if method != r.Method {
+			span.setAttr("http.request.method_original", r.Method)
 		}
+		span.setAttr("http.route", route)
+		span.setAttr("url.path", r.URL.Path)
+		span.setAttr("user_agent.original", r.UserAgent())
+		span.setAttr("yabt.request_id", requestID)
+		r = r.WithContext(ctx)
 
 		// Create request log entry
-		// Build full path with query string
-		fullPath := r.URL.Path
-		if r.URL.RawQuery != "" {
-			fullPath = fullPath + "?" + r.URL.RawQuery
-		}
+		// Build full path with query string, redacting sensitive parameters
+		fullPath := a.redaction.requestPath(r.URL)
 
-		reqEntry := &LogEntry{
-			RequestID: requestID,
-			Method:    r.Method,
-			Path:      fullPath,
-			IP:        ip,
-			UserAgent: r.UserAgent(),
-			Referer:   r.Header.Get("Referer"),
-		}
+		span.setAttr("client.address", ip)
 
-		// Attempt to extract user from Authorization header
+		// Request-scoped logger, so handlers log with the same correlation fields
+		reqLogger := logger.With("requestId", requestID, "traceId", span.traceID(), "route", route)
+
+		// Log the user only when the bearer token checks out without a
+		// round trip; an unverified token could name anyone
 		authHeader := r.Header.Get("Authorization")
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			reqEntry.User = extractUserFromJWT(token)
+			if user := verifiedSubject(a.cfg.Supabase, token); user != "" {
+				reqLogger = reqLogger.With("user", user)
+			}
+		}
+		r = r.WithContext(withLogger(r.Context(), reqLogger))
+
+		reqAttrs := []any{
+			"method", r.Method,
+			"path", fullPath,
+			"ip", ip,
+			"userAgent", r.UserAgent(),
+			"referer", r.Header.Get("Referer"),
 		}
 
 		// Log request body if enabled
-		if logRequestBody && r.Body != nil && r.ContentLength > 0 {
+		if a.cfg.Log.RequestBody && !a.redaction.privacy && r.Body != nil && r.ContentLength > 0 && r.ContentLength <= maxLoggedBodyBytes {
 			bodyBytes, err := io.ReadAll(r.Body)
 			if err == nil {
 				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 				var bodyData map[string]interface{}
 				if json.Unmarshal(bodyBytes, &bodyData) == nil {
-					reqEntry.Body = redactSensitiveFields(bodyData)
+					reqAttrs = append(reqAttrs, "body", bodyData)
 				}
 			}
 		}
 
-		logJSON("info", fmt.Sprintf("→ %s %s", r.Method, fullPath), reqEntry)
+		reqLogger.Info(fmt.Sprintf("→ %s %s", r.Method, fullPath), reqAttrs...)
 
 		// Wrap response writer
 		rw := newResponseWriter(w)
@@ -806,146 +885,239 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		// Calculate response time
 		responseTime := time.Since(startTime)
 
-		// Create response log entry
-		respEntry := &LogEntry{
-			RequestID:    requestID,
-			Method:       r.Method,
-			Path:         fullPath,
-			StatusCode:   rw.statusCode,
-			ResponseTime: responseTime.String(),
-			IP:           ip,
-			User:         reqEntry.User, // Pass user to response log
+		status := strconv.Itoa(rw.statusCode)
+		httpRequestsTotal.inc(route, method, status)
+		httpRequestDuration.observe(responseTime.Seconds(), route, method, status)
+
+		span.setHTTPStatus(rw.statusCode)
+		span.finish()
+
+		respAttrs := []any{
+			"method", r.Method,
+			"path", fullPath,
+			"statusCode", rw.statusCode,
+			"responseTime", responseTime.String(),
+			"ip", ip,
 		}
 
 		// Log response body for JSON responses if enabled
-		if logRequestBody && rw.body.Len() > 0 && rw.body.Len() < 5000 {
+		if a.cfg.Log.RequestBody && !a.redaction.privacy && rw.body.Len() > 0 && rw.body.Len() < 5000 {
 			contentType := rw.Header().Get("Content-Type")
 			if strings.Contains(contentType, "application/json") {
 				var bodyData map[string]interface{}
 				if json.Unmarshal(rw.body.Bytes(), &bodyData) == nil {
-					respEntry.Body = redactSensitiveFields(bodyData)
+					respAttrs = append(respAttrs, "body", bodyData)
 				}
 			}
 		}
 
 		// Determine log level based on status code
-		level := "info"
+		level := slog.LevelInfo
 		if rw.statusCode >= 500 {
-			level = "error"
+			level = slog.LevelError
 		} else if rw.statusCode >= 400 {
-			level = "warn"
+			level = slog.LevelWarn
 		}
 
-		logJSON(level, fmt.Sprintf("← %d %s %s (%s)", rw.statusCode, r.Method, fullPath, responseTime), respEntry)
+		reqLogger.Log(r.Context(), level, fmt.Sprintf("← %d %s %s (%s)", rw.statusCode, r.Method, fullPath, responseTime), respAttrs...)
 	})
 }
 
-// Health check handler
-func healthHandler(w http.ResponseWriter, r *http.Request) {
+// Health check handler (liveness: the process is up and serving)
+func (a *app) healthHandler(w http.ResponseWriter, r *http.Request) {
 	uptime := time.Since(startTime)
 
 	health := HealthResponse{
 		Status:      "healthy",
 		Timestamp:   time.Now().UTC().Format(time.RFC3339),
 		Uptime:      uptime.String(),
-		Version:     "1.0.0",
-		Environment: nodeEnv,
+		Version:     version,
+		Commit:      buildCommit(),
+		Environment: a.cfg.Environment,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(health)
 }
 
-// Log API handler for manual log shipping
-func logAPIHandler(w http.ResponseWriter, r *http.Request) {
+// clientLogEntry is one entry sent by the browser logger.
+type clientLogEntry struct {
+	Level     string                 `json:"level"`
+	Message   string                 `json:"message"`
+	Timestamp string                 `json:"timestamp"`
+	Meta      map[string]interface{} `json:"meta"`
+}
+
+// Log API handler for frontend log ingestion. Accepts a single entry or an
+// array of entries; the user is taken from the verified session token,
+// never from the payload.
+func (a *app) logAPIHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		Level   string                 `json:"level"`
-		Message string                 `json:"message"`
-		User    string                 `json:"user"`
-		Meta    map[string]interface{} `json:"meta"`
-	}
+	ctx := r.Context()
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if ok, wait := a.logAPIIPLimiter.allow(clientIP(r), 1); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, "Too many log requests, slow down")
 		return
 	}
 
-	if req.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+	user := ""
+	if token := getAPIKeyFromRequest(r); token != "" {
+		verified, err := verifySupabaseJWT(ctx, a.cfg.Supabase, token)
+		switch {
+		case err == nil:
+			user = verified
+		case errors.Is(err, errInvalidToken):
+			writeJSONError(w, http.StatusUnauthorized, "Invalid or expired session")
+			return
+		case errors.Is(err, errTokenUnverifiable):
+			// Accepted, but logged without a user
+		default:
+			loggerFrom(ctx).Warn("Could not verify log API session", "error", err)
+		}
+	}
+	if user == "" && a.cfg.LogAPI.RequireAuth {
+		writeJSONError(w, http.StatusUnauthorized, "Authentication required")
 		return
 	}
 
-	if req.Level == "" {
-		req.Level = "info"
+	r.Body = http.MaxBytesReader(w, r.Body, int64(a.cfg.LogAPI.MaxBytes))
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Log payload too large")
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "Failed to read request body")
+		return
 	}
 
-	entry := &LogEntry{
-		User: req.User,
+	var entries []clientLogEntry
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		err = json.Unmarshal(raw, &entries)
+	} else {
+		var entry clientLogEntry
+		err = json.Unmarshal(raw, &entry)
+		entries = []clientLogEntry{entry}
 	}
-	logJSON(req.Level, req.Message, entry)
-
-	// Ship to webhook
-	if logWebhookURL != "" {
-		go shipToWebhook(entry)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if len(entries) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "No log entries")
+		return
+	}
+	if len(entries) > a.cfg.LogAPI.MaxBatch {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("At most %d log entries per request", a.cfg.LogAPI.MaxBatch))
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "Log shipped",
-	})
-}
-
-// Static file server with SPA fallback
-func spaHandler(distPath string) http.Handler {
-	fileServer := http.FileServer(http.Dir(distPath))
-
-	// Regex to detect file extensions
-	fileExtRegex := regexp.MustCompile(`\.\w+$`)
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-
-		// Check if the file exists
-		fullPath := filepath.Join(distPath, path)
-		if _, err := os.Stat(fullPath); err == nil {
-			fileServer.ServeHTTP(w, r)
+	levels := make([]slog.Level, len(entries))
+	for i, entry := range entries {
+		if strings.TrimSpace(entry.Message) == "" {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Entry %d: message is required", i))
 			return
 		}
-
-		// If it's a file request (has extension) and doesn't exist, return 404
-		if fileExtRegex.MatchString(path) {
-			http.NotFound(w, r)
+		if entry.Level == "" {
+			entry.Level = "info"
+		}
+		level, ok := parseLogLevel(entry.Level)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Entry %d: level must be one of debug, info, warn, error", i))
 			return
 		}
+		levels[i] = level
+	}
 
-		// SPA fallback - serve index.html
-		indexPath := filepath.Join(distPath, "index.html")
-		if _, err := os.Stat(indexPath); err == nil {
-			http.ServeFile(w, r, indexPath)
-			return
+	limitKey := "ip:" + clientIP(r)
+	if user != "" {
+		limitKey = "user:" + user
+	}
+	if ok, wait := a.logAPILimiter.allow(limitKey, len(entries)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, "Too many log entries, slow down")
+		return
+	}
+
+	clientLogger := loggerFrom(ctx).With("source", "client", "user", user)
+	for i, entry := range entries {
+		attrs := []any{}
+		if entry.Timestamp != "" {
+			attrs = append(attrs, "clientTime", truncateString(entry.Timestamp, 64))
+		}
+		if len(entry.Meta) > 0 {
+			attrs = append(attrs, "meta", sanitizeLogMeta(entry.Meta, 0))
 		}
+		clientLogger.Log(ctx, levels[i], truncateString(entry.Message, logAPIMaxMessage), attrs...)
+	}
 
-		http.Error(w, "Application not built. Run npm run build first.", http.StatusNotFound)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"message":  "Log shipped",
+		"accepted": len(entries),
 	})
 }
 
+// sanitizeLogMeta bounds client-supplied metadata before it is logged:
+// nesting depth, number of keys and items, and string length are capped.
+// Sensitive keys and values are then handled by the redaction policy.
+func sanitizeLogMeta(v interface{}, depth int) interface{} {
+	const (
+		maxDepth  = 4
+		maxItems  = 50
+		maxString = 1000
+	)
+
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		if depth >= maxDepth {
+			return "[TRUNCATED]"
+		}
+		result := make(map[string]interface{}, len(typed))
+		for key, value := range typed {
+			if len(result) >= maxItems {
+				result["_truncated"] = true
+				break
+			}
+			result[truncateString(key, 100)] = sanitizeLogMeta(value, depth+1)
+		}
+		return result
+	case []interface{}:
+		if depth >= maxDepth {
+			return "[TRUNCATED]"
+		}
+		n := len(typed)
+		if n > maxItems {
+			n = maxItems
+		}
+		result := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			result[i] = sanitizeLogMeta(typed[i], depth+1)
+		}
+		return result
+	case string:
+		return truncateString(typed, maxString)
+	default:
+		return typed
+	}
+}
+
 // Ollama AI Proxy - forwards requests to Ollama Cloud API to bypass CORS
-func ollamaProxyHandler(w http.ResponseWriter, r *http.Request) {
+func (a *app) ollamaProxyHandler(w http.ResponseWriter, r *http.Request) {
 	// Only allow POST requests
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Check if API key is configured
-	if ollamaAPIKey == "" {
-		http.Error(w, "Ollama API key not configured", http.StatusInternalServerError)
+	if !a.requireFeature(w, featureAIChat, a.sessionSubject(r)) {
 		return
 	}
 
@@ -958,20 +1130,32 @@ func ollamaProxyHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	// Forward request to Ollama Cloud API
-	req, err := http.NewRequest("POST", "https://ollama.com/api/chat", bytes.NewBuffer(body))
+	ctx, span := startSpan(r.Context(), "ollama.chat", spanKindClient)
+	defer span.finish()
+	span.setAttr("gen_ai.system", "ollama")
+
+	req, err := newOutboundRequest(ctx, "POST", "https://ollama.com/api/chat", bytes.NewBuffer(body))
 	if err != nil {
 		http.Error(w, "Failed to create request", http.StatusInternalServerError)
 		return
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+ollamaAPIKey)
+	req.Header.Set("Authorization", "Bearer "+a.cfg.AI.OllamaAPIKey)
 
 	// Make request
 	client := &http.Client{Timeout: 60 * time.Second}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		logJSON("error", "Ollama API request failed", &LogEntry{Error: err.Error()})
+		observeOutbound("ollama", start, false)
+		span.setError(err)
+		if r.Context().Err() != nil {
+			loggerFrom(r.Context()).Warn("Ollama API request abandoned", "error", err)
+			http.Error(w, "Ollama API request timed out", http.StatusGatewayTimeout)
+			return
+		}
+		loggerFrom(r.Context()).Error("Ollama API request failed", "error", err)
 		http.Error(w, "Failed to contact Ollama API: "+err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -979,7 +1163,10 @@ func ollamaProxyHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Read response
 	respBody, err := io.ReadAll(resp.Body)
+	observeOutbound("ollama", start, err == nil && resp.StatusCode < 300)
+	span.setHTTPStatus(resp.StatusCode)
 	if err != nil {
+		span.setError(err)
 		http.Error(w, "Failed to read Ollama response", http.StatusInternalServerError)
 		return
 	}
@@ -991,14 +1178,13 @@ func ollamaProxyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // Transcribe Audio using Groq Whisper API
-func transcribeHandler(w http.ResponseWriter, r *http.Request) {
+func (a *app) transcribeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if groqAPIKey == "" {
-		http.Error(w, "Groq API key not configured", http.StatusInternalServerError)
+	if !a.requireFeature(w, featureVoice, a.sessionSubject(r)) {
 		return
 	}
 
@@ -1036,20 +1222,33 @@ func transcribeHandler(w http.ResponseWriter, r *http.Request) {
 	writer.Close()
 
 	// Create request
-	req, err := http.NewRequest("POST", "https://api.groq.com/openai/v1/audio/transcriptions", body)
+	ctx, span := startSpan(r.Context(), "groq.transcribe", spanKindClient)
+	defer span.finish()
+	span.setAttr("gen_ai.system", "groq")
+	span.setAttr("gen_ai.request.model", "whisper-large-v3")
+
+	req, err := newOutboundRequest(ctx, "POST", "https://api.groq.com/openai/v1/audio/transcriptions", body)
 	if err != nil {
 		http.Error(w, "Error creating request", http.StatusInternalServerError)
 		return
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+groqAPIKey)
+	req.Header.Set("Authorization", "Bearer "+a.cfg.AI.GroqAPIKey)
 
 	// Send request
 	client := &http.Client{Timeout: 60 * time.Second}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		logJSON("error", "Groq API request failed", &LogEntry{Error: err.Error()})
+		observeOutbound("groq", start, false)
+		span.setError(err)
+		if r.Context().Err() != nil {
+			loggerFrom(r.Context()).Warn("Groq API request abandoned", "error", err)
+			http.Error(w, "Groq API request timed out", http.StatusGatewayTimeout)
+			return
+		}
+		loggerFrom(r.Context()).Error("Groq API request failed", "error", err)
 		http.Error(w, "Failed to contact Groq API", http.StatusBadGateway)
 		return
 	}
@@ -1057,13 +1256,16 @@ func transcribeHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Read response
 	respBody, err := io.ReadAll(resp.Body)
+	observeOutbound("groq", start, err == nil && resp.StatusCode == http.StatusOK)
+	span.setHTTPStatus(resp.StatusCode)
 	if err != nil {
+		span.setError(err)
 		http.Error(w, "Failed to read Groq response", http.StatusInternalServerError)
 		return
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		logJSON("error", fmt.Sprintf("Groq API error: %s", string(respBody)), nil)
+		loggerFrom(r.Context()).Error("Groq API error", "upstreamStatus", resp.StatusCode, "response", truncateString(string(respBody), 500))
 		http.Error(w, "Groq API error", resp.StatusCode)
 		return
 	}
@@ -1072,76 +1274,36 @@ func transcribeHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(respBody)
 }
 
-func shortcutTransactionHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if supabaseURL == "" || supabaseKey == "" {
-		writeJSONError(w, http.StatusInternalServerError, "Supabase service role key not configured")
-		return
-	}
-
-	if ollamaAPIKey == "" {
-		writeJSONError(w, http.StatusInternalServerError, "Ollama API key not configured")
-		return
-	}
-
-	apiKey := getAPIKeyFromRequest(r)
-	if apiKey == "" {
-		writeJSONError(w, http.StatusUnauthorized, "API key required")
-		return
-	}
-
-	var req shortcutRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Invalid JSON payload")
-		return
-	}
-
-	text := parseShortcutText(req)
-	if text == "" {
-		writeJSONError(w, http.StatusBadRequest, "Transaction text is required")
-		return
-	}
+// recordedTransaction is a transaction saved by recordParsedTransaction.
+type recordedTransaction struct {
+	ID        string
+	AccountID string
+	Account   string
+	Category  string
+	Amount    float64 // signed: negative for expenses
+	Date      string
+}
 
-	sb := newSupabaseClient(supabaseURL, supabaseKey)
+// recordError names the step of recordParsedTransaction that failed.
+type recordError struct {
+	message string
+	err     error
+}
 
-	keyHash := hashAPIKey(apiKey)
-	keyQuery := url.Values{}
-	keyQuery.Set("key_hash", "eq."+keyHash)
-	keyQuery.Set("select", "id,user_id,budget_id,name")
+func (e *recordError) Error() string { return e.message + ": " + e.err.Error() }
+func (e *recordError) Unwrap() error { return e.err }
 
-	var keyRecords []apiKeyRecord
-	if err := sb.request("GET", "api_keys", keyQuery, nil, &keyRecords); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to verify API key")
-		return
-	}
+// recordParsedTransaction saves a parsed transaction to a budget: into the
+// named account, or an Inbox account created on first use, with the learned
+// or best-matching category and the payee, created when new. The shortcut
+// API and the import command both go through here.
+func recordParsedTransaction(ctx context.Context, store dataStore, budgetID string, parsed parsedTransaction) (*recordedTransaction, error) {
+	span := spanFromContext(ctx)
+	reqLog := loggerFrom(ctx).With("budgetId", budgetID)
 
-	if len(keyRecords) == 0 {
-		writeJSONError(w, http.StatusUnauthorized, "Invalid API key")
-		return
-	}
-
-	keyRecord := keyRecords[0]
-
-	parsed, err := parseAITransaction(text)
+	accounts, err := store.listOpenAccounts(ctx, budgetID)
 	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, "Failed to parse transaction text")
-		return
-	}
-
-	// Fetch accounts for budget
-	accountsQuery := url.Values{}
-	accountsQuery.Set("budget_id", "eq."+keyRecord.BudgetID)
-	accountsQuery.Set("closed", "eq.false")
-	accountsQuery.Set("select", "id,budget_id,name,account_type,balance,is_on_budget,closed")
-
-	var accounts []accountRecord
-	if err := sb.request("GET", "accounts", accountsQuery, nil, &accounts); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to load accounts")
-		return
+		return nil, &recordError{"Failed to load accounts", err}
 	}
 
 	var account *accountRecord
@@ -1159,40 +1321,36 @@ func shortcutTransactionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if account == nil {
-		payload := map[string]interface{}{
-			"budget_id":    keyRecord.BudgetID,
-			"name":         "Inbox",
-			"account_type": "savings",
-			"balance":      0,
-			"is_on_budget": true,
-			"closed":       false,
-			"sort_order":   999,
+		inbox := accountRecord{
+			BudgetID:    budgetID,
+			Name:        "Inbox",
+			AccountType: "savings",
+			Balance:     0,
+			IsOnBudget:  true,
+			Closed:      false,
 		}
 
-		var created []accountRecord
-		if err := sb.request("POST", "accounts", nil, payload, &created); err != nil || len(created) == 0 {
-			writeJSONError(w, http.StatusInternalServerError, "Failed to create Inbox account")
-			return
+		created, err := store.createAccount(ctx, inbox, 999)
+		if err != nil {
+			return nil, &recordError{"Failed to create Inbox account", err}
 		}
-		account = &created[0]
+		account = created
+		reqLog.Info("Created Inbox account", "accountId", created.ID)
 	}
 
 	var categoryID string
 	categoryName := ""
 	usedLearnedCategory := false
+	matchStrategy := "none"
 
 	if parsed.Payee != "" {
-		rulesQuery := url.Values{}
-		rulesQuery.Set("budget_id", "eq."+keyRecord.BudgetID)
-		rulesQuery.Set("select", "payee_name,category_id")
-
-		var rules []payeeCategoryRuleRecord
-		if err := sb.request("GET", "payee_category_rules", rulesQuery, nil, &rules); err == nil {
+		if rules, err := store.listPayeeCategoryRules(ctx, budgetID); err == nil {
 			normalizedPayee := normalizeMatchString(parsed.Payee)
 			for _, rule := range rules {
 				if normalizeMatchString(rule.PayeeName) == normalizedPayee {
 					categoryID = rule.CategoryID
 					usedLearnedCategory = true
+					matchStrategy = "learned_rule"
 					break
 				}
 			}
@@ -1200,60 +1358,44 @@ func shortcutTransactionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if categoryID == "" && parsed.Category != "" {
-		groupQuery := url.Values{}
-		groupQuery.Set("budget_id", "eq."+keyRecord.BudgetID)
-		groupQuery.Set("select", "id")
-
-		var groups []categoryGroupRecord
-		if err := sb.request("GET", "category_groups", groupQuery, nil, &groups); err == nil && len(groups) > 0 {
-			groupIDs := make([]string, 0, len(groups))
-			for _, group := range groups {
-				groupIDs = append(groupIDs, group.ID)
+		if categories, err := store.listCategories(ctx, budgetID); err == nil && len(categories) > 0 {
+			if match := matchCategory(categories, parsed.Category); match != nil {
+				categoryID = match.ID
+				categoryName = match.Name
+				matchStrategy = "fuzzy"
 			}
 
-			categoryQuery := url.Values{}
-			categoryQuery.Set("category_group_id", "in.("+strings.Join(groupIDs, ",")+")")
-			categoryQuery.Set("select", "id,name,category_group_id")
-
-			var categories []categoryRecord
-			if err := sb.request("GET", "categories", categoryQuery, nil, &categories); err == nil {
-				if match := matchCategory(categories, parsed.Category); match != nil {
-					categoryID = match.ID
-					categoryName = match.Name
-				}
-
-				if categoryID == "" {
-					for _, cat := range categories {
-						if cat.Name == "Uncategorized" {
-							categoryID = cat.ID
-							categoryName = cat.Name
-							break
-						}
+			if categoryID == "" {
+				for _, cat := range categories {
+					if cat.Name == "Uncategorized" {
+						categoryID = cat.ID
+						categoryName = cat.Name
+						matchStrategy = "uncategorized"
+						break
 					}
 				}
 			}
 		}
 	}
 
+	categoryMatchTotal.inc(matchStrategy)
+	span.setAttr("yabt.category.strategy", matchStrategy)
+	reqLog.Info("Resolved transaction category", "strategy", matchStrategy, "categoryId", categoryID, "requestedCategory", parsed.Category)
+
 	var payeeID string
 	if parsed.Payee != "" {
-		payeeQuery := url.Values{}
-		payeeQuery.Set("budget_id", "eq."+keyRecord.BudgetID)
-		payeeQuery.Set("name", "ilike."+parsed.Payee)
-		payeeQuery.Set("select", "id,name")
-
-		var payees []payeeRecord
-		if err := sb.request("GET", "payees", payeeQuery, nil, &payees); err == nil && len(payees) > 0 {
-			payeeID = payees[0].ID
+		payee, err := store.findPayeeByName(ctx, budgetID, parsed.Payee)
+		if err == nil && payee != nil {
+			payeeID = payee.ID
 		} else if err == nil {
-			payload := map[string]interface{}{
-				"budget_id": keyRecord.BudgetID,
-				"name":      parsed.Payee,
-			}
-			var created []payeeRecord
-			if err := sb.request("POST", "payees", nil, payload, &created); err == nil && len(created) > 0 {
-				payeeID = created[0].ID
+			if created, err := store.createPayee(ctx, budgetID, parsed.Payee); err == nil {
+				payeeID = created.ID
+				reqLog.Info("Created payee", "payeeId", created.ID, "payee", parsed.Payee)
+			} else {
+				reqLog.Warn("Failed to create payee", "payee", parsed.Payee, "error", err)
 			}
+		} else {
+			reqLog.Warn("Failed to look up payee", "payee", parsed.Payee, "error", err)
 		}
 	}
 
@@ -1262,121 +1404,250 @@ func shortcutTransactionHandler(w http.ResponseWriter, r *http.Request) {
 		transactionDate = time.Now().UTC().Format("2006-01-02")
 	}
 
-	memo := parsed.Memo
-	if memo == "" {
-		memoSource := strings.TrimSpace(text)
-		if memoSource != "" {
-			memo = fmt.Sprintf("[Shortcut] %s", truncateString(memoSource, 60))
-		}
-	}
-
 	amount := math.Abs(parsed.Amount)
 	if parsed.Type == "expense" {
 		amount = -amount
 	}
 
-	transactionPayload := map[string]interface{}{
-		"account_id":          account.ID,
-		"category_id":         nil,
-		"payee_id":            nil,
-		"transfer_account_id": nil,
-		"date":                transactionDate,
-		"amount":              amount,
-		"memo":                memo,
-		"cleared":             false,
-		"approved":            true,
+	createdTx, err := store.createTransaction(ctx, transactionInput{
+		AccountID:  account.ID,
+		CategoryID: categoryID,
+		PayeeID:    payeeID,
+		Date:       transactionDate,
+		Amount:     amount,
+		Memo:       parsed.Memo,
+		Cleared:    false,
+		Approved:   true,
+	})
+	if err != nil {
+		return nil, &recordError{"Failed to create transaction", err}
+	}
+
+	if err := store.updateAccountBalance(ctx, account.ID, account.Balance+amount); err != nil {
+		reqLog.Warn("Failed to update account balance", "accountId", account.ID, "error", err)
+	}
+
+	if parsed.Payee != "" && categoryID != "" && !usedLearnedCategory {
+		if err := store.upsertPayeeCategoryRule(ctx, budgetID, strings.ToLower(strings.TrimSpace(parsed.Payee)), categoryID); err != nil {
+			reqLog.Warn("Failed to learn payee category", "payee", parsed.Payee, "error", err)
+		}
+	}
+
+	recorded := &recordedTransaction{
+		ID:        createdTx.ID,
+		AccountID: account.ID,
+		Account:   account.Name,
+		Category:  categoryName,
+		Amount:    amount,
+		Date:      transactionDate,
 	}
+	if recorded.Category == "" {
+		recorded.Category = parsed.Category
+	}
+	return recorded, nil
+}
 
-	if categoryID != "" {
-		transactionPayload["category_id"] = categoryID
+func (a *app) shortcutTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	if payeeID != "" {
-		transactionPayload["payee_id"] = payeeID
+
+	// Needs storage and an AI provider; per-user and per-budget settings
+	// are checked once the API key identifies both
+	if !a.requireServerFeature(w, featureShortcuts) {
+		return
 	}
 
-	var createdTx []transactionRecord
-	if err := sb.request("POST", "transactions", nil, transactionPayload, &createdTx); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "Failed to create transaction")
+	apiKey := getAPIKeyFromRequest(r)
+	if apiKey == "" {
+		writeJSONError(w, http.StatusUnauthorized, "API key required")
 		return
 	}
 
-	transactionID := ""
-	if len(createdTx) > 0 {
-		transactionID = createdTx[0].ID
+	var req shortcutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON payload")
+		return
 	}
 
-	accountUpdateQuery := url.Values{}
-	accountUpdateQuery.Set("id", "eq."+account.ID)
-	_ = sb.request("PATCH", "accounts", accountUpdateQuery, map[string]interface{}{
-		"balance": account.Balance + amount,
-	}, nil)
+	text := parseShortcutText(req)
+	if text == "" {
+		writeJSONError(w, http.StatusBadRequest, "Transaction text is required")
+		return
+	}
 
-	if parsed.Payee != "" && categoryID != "" && !usedLearnedCategory {
-		rulePayload := map[string]interface{}{
-			"budget_id":  keyRecord.BudgetID,
-			"payee_name": strings.ToLower(strings.TrimSpace(parsed.Payee)),
-			"category_id": categoryID,
+	ctx := r.Context()
+
+	keyRecord, err := a.store.findAPIKeyByHash(ctx, hashAPIKey(apiKey))
+	if err != nil {
+		writeStoreError(w, err, "Failed to verify API key")
+		return
+	}
+
+	if keyRecord == nil {
+		writeJSONError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	budget, err := a.store.getBudget(ctx, keyRecord.BudgetID)
+	if err != nil {
+		writeStoreError(w, err, "Failed to load budget")
+		return
+	}
+	if !a.requireFeature(w, featureShortcuts, featureSubject{userID: keyRecord.UserID, budget: budget}) {
+		return
+	}
+
+	span := spanFromContext(ctx)
+	span.setAttr("yabt.budget_id", keyRecord.BudgetID)
+	reqLog := loggerFrom(ctx).With("budgetId", keyRecord.BudgetID)
+
+	parsed, err := parseAITransaction(ctx, a.cfg.AI, text)
+	if err != nil {
+		aiParseTotal.inc("failure")
+		reqLog.Warn("Failed to parse shortcut text", "error", err)
+		if ctx.Err() != nil {
+			writeJSONError(w, http.StatusGatewayTimeout, "Timed out parsing transaction text")
+			return
 		}
-		ruleQuery := url.Values{}
-		ruleQuery.Set("on_conflict", "budget_id,payee_name")
-		_ = sb.requestWithPrefer("POST", "payee_category_rules", ruleQuery, rulePayload, nil, "resolution=merge-duplicates")
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse transaction text")
+		return
 	}
+	aiParseTotal.inc("success")
+	reqLog.Debug("Parsed shortcut text", "amount", parsed.Amount, "type", parsed.Type, "payee", parsed.Payee, "category", parsed.Category, "account", parsed.Account)
 
-	keyUpdateQuery := url.Values{}
-	keyUpdateQuery.Set("id", "eq."+keyRecord.ID)
-	_ = sb.request("PATCH", "api_keys", keyUpdateQuery, map[string]interface{}{
-		"last_used_at": time.Now().UTC().Format(time.RFC3339),
-	}, nil)
+	if parsed.Memo == "" {
+		if memoSource := strings.TrimSpace(text); memoSource != "" {
+			parsed.Memo = fmt.Sprintf("[Shortcut] %s", truncateString(memoSource, 60))
+		}
+	}
+
+	recorded, err := recordParsedTransaction(ctx, a.store, keyRecord.BudgetID, parsed)
+	if err != nil {
+		var recErr *recordError
+		if errors.As(err, &recErr) {
+			writeStoreError(w, recErr.err, recErr.message)
+			return
+		}
+		writeStoreError(w, err, "Failed to create transaction")
+		return
+	}
+
+	_ = a.store.touchAPIKey(ctx, keyRecord.ID, time.Now())
+
+	reqLog.Info("Shortcut transaction created", "transactionId", recorded.ID, "accountId", recorded.AccountID, "amount", recorded.Amount)
 
 	response := shortcutResponse{
 		Success:       true,
 		Message:       "Transaction created",
-		TransactionID: transactionID,
-		Amount:        amount,
+		TransactionID: recorded.ID,
+		Amount:        recorded.Amount,
 		Payee:         parsed.Payee,
-		Category:      categoryName,
-		Account:       account.Name,
-		Date:          transactionDate,
+		Category:      recorded.Category,
+		Account:       recorded.Account,
+		Date:          recorded.Date,
 		Parsed:        &parsed,
 	}
 
-	if response.Category == "" {
-		response.Category = parsed.Category
-	}
-
 	writeJSON(w, http.StatusOK, response)
 }
 
 func main() {
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// serveCommand runs the HTTP server until it is shut down by a signal.
+func serveCommand(cfg *Config, args []string) error {
+	flags := newFlagSet("serve", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageError(flags, "unexpected argument %q", flags.Arg(0))
+	}
+
+	// Start log sinks and send logs to them from here on
+	sinks, sinkErrs := newLogSinks(cfg.Log.Sinks, cfg.Environment)
+	configureLogging(cfg, os.Stdout, sinks)
+	registerLogQueueGauge(sinks)
+	defer flushBackground(sinks)
+	for _, err := range sinkErrs {
+		logger.Warn("Log sink disabled", "error", err)
+	}
+
+	// Bring the schema up to date before anything queries it
+	if cfg.Migrate.OnStart {
+		ctx, cancel := commandContext()
+		err := runStartupMigrations(ctx, cfg.migrationDSN())
+		cancel()
+		if err != nil {
+			return fmt.Errorf("migrations failed: %w", err)
+		}
+	}
+
+	// Connect storage backend
+	store, storeErr := newDataStore(cfg.Storage, cfg.Supabase)
+	if storeErr != nil {
+		logger.Warn("Storage backend unavailable", "error", storeErr)
+	}
+	defer closeStore(store)
+	lc := newLifecycle()
+	startDataJobs(lc, cfg.Jobs, store)
+
+	frontend, frontendSource := frontendFS(cfg.Static.DistPath)
+
 	// Create router
+	a := newApp(cfg, lc, store, storeErr, frontend)
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/api/log", logAPIHandler)
-	mux.HandleFunc("/api/ai/chat", ollamaProxyHandler)
-	mux.HandleFunc("/api/ai/transcribe", transcribeHandler)
-	mux.HandleFunc("/api/shortcut/transaction", shortcutTransactionHandler)
+	mux.HandleFunc("/health", a.healthHandler)
+	mux.HandleFunc("/health/live", a.healthHandler)
+	mux.HandleFunc("/health/ready", a.readinessHandler)
+	mux.HandleFunc("/metrics", a.metricsHandler)
+	mux.HandleFunc("/api/log", a.logAPIHandler)
+	mux.HandleFunc(cspReportPath, a.cspReportHandler)
+	mux.HandleFunc("/api/config", a.configHandler)
+	mux.HandleFunc("/api/features", a.featuresHandler)
+	mux.HandleFunc("/config.js", a.configScriptHandler)
+	mux.HandleFunc("/api/ai/chat", withDeadline(aiChatDeadline, a.ollamaProxyHandler))
+	mux.HandleFunc("/api/ai/transcribe", withDeadline(transcribeDeadline, a.transcribeHandler))
+	mux.HandleFunc("/api/shortcut/transaction", withDeadline(shortcutDeadline, a.shortcutTransactionHandler))
 
 	// Static files and SPA fallback
-	mux.Handle("/", spaHandler(distPath))
+	mux.Handle("/", spaHandler(frontend, cfg.Static))
 
-	// Wrap with logging middleware
-	handler := loggingMiddleware(mux)
+	// Wrap with logging and security header middleware
+	handler := hstsMiddleware(cfg.TLS.HSTSMaxAge, securityHeadersMiddleware(cfg, a.loggingMiddleware(mux)))
 
 	// Log startup
-	logJSON("info", "Server started", &LogEntry{
-		Message: fmt.Sprintf("Listening on port %s", port),
-	})
-
-	fmt.Printf("🚀 YABT server running on http://0.0.0.0:%s\n", port)
-	fmt.Printf("📊 Log level: %s\n", logLevel)
-	fmt.Printf("🔗 Webhook: %v (all logs: %v)\n", logWebhookURL != "", logWebhookAll)
-	fmt.Printf("📝 Request body logging: %v\n", logRequestBody)
-
-	// Start server
-	addr := fmt.Sprintf("0.0.0.0:%s", port)
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	scheme := "http"
+	if cfg.TLS.enabled() {
+		scheme = "https"
+	}
+	logger.Info("Server started",
+		"url", fmt.Sprintf("%s://0.0.0.0:%s", scheme, cfg.Port),
+		"redirectPort", cfg.TLS.HTTPPort,
+		"logLevel", cfg.Log.Level,
+		"logSinks", logSinkNames(sinks),
+		"requestBodyLogging", cfg.Log.RequestBody,
+		"frontend", frontendSource,
+		"storageBackend", cfg.Storage.Backend,
+		"storageConnected", store != nil,
+	)
+	logger.Info("Configuration", "configFile", cfg.source.path, "settings", cfg.source.summary())
+
+	// Start server; returns once shut down by a signal or a listener error
+	addr := fmt.Sprintf("0.0.0.0:%s", cfg.Port)
+	servers, err := newServers(addr, handler, cfg, lc)
+	if err != nil {
+		return fmt.Errorf("TLS setup failed: %w", err)
+	}
+	err = serve(cfg.Server, lc, servers...)
+	logger.Info("Shutdown complete")
+	if err != nil {
+		return fmt.Errorf("server failed to start: %w", err)
 	}
+	return nil
 }
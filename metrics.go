@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal Prometheus text exposition (format 0.0.4). The server only needs
+// counters, histograms and a couple of constant gauges, so this avoids
+// pulling in client_golang.
+
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+var outboundLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60}
+
+type metricCollector interface {
+	writeTo(w io.Writer)
+}
+
+type metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []metricCollector
+}
+
+func (r *metricsRegistry) register(c metricCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *metricsRegistry) writeTo(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]metricCollector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.writeTo(w)
+	}
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	if len(labels) == 0 {
+		c.values[""] = 0
+	}
+	metrics.register(c)
+	return c
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counterVec) add(delta float64, labelValues ...string) {
+	key := metricKey(labelValues)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, splitMetricKey(key), "", ""), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	metrics.register(h)
+	return h
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := metricKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+		values := splitMetricKey(key)
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(upper)), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values, "", ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values, "", ""), series.count)
+	}
+}
+
+// gaugeFunc reports a value computed at scrape time.
+type gaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() ([]string, float64)
+}
+
+func newGaugeFunc(name, help string, labels []string, fn func() ([]string, float64)) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, labels: labels, fn: fn}
+	metrics.register(g)
+	return g
+}
+
+func (g *gaugeFunc) writeTo(w io.Writer) {
+	labelValues, value := g.fn()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, labelValues, "", ""), formatFloat(value))
+}
+
+func metricKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func splitMetricKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Server metrics
+var (
+	metrics = &metricsRegistry{}
+
+	httpRequestsTotal = newCounterVec("yabt_http_requests_total",
+		"HTTP requests handled, by route, method and status.", "route", "method", "status")
+	httpRequestDuration = newHistogramVec("yabt_http_request_duration_seconds",
+		"HTTP request latency, by route, method and status.", defaultLatencyBuckets, "route", "method", "status")
+
+	outboundRequestsTotal = newCounterVec("yabt_outbound_requests_total",
+		"Outbound calls to upstream services, by service and result.", "service", "result")
+	outboundRequestDuration = newHistogramVec("yabt_outbound_request_duration_seconds",
+		"Outbound call latency, by service.", outboundLatencyBuckets, "service")
+
+	aiParseTotal = newCounterVec("yabt_ai_parse_total",
+		"AI transaction parse attempts, by result.", "result")
+	categoryMatchTotal = newCounterVec("yabt_category_match_total",
+		"Shortcut category resolution, by strategy (learned_rule, fuzzy, uncategorized, none).", "strategy")
+
+	logShippedTotal = newCounterVec("yabt_log_shipped_total",
+		"Log entries delivered to a log sink, by sink.", "sink")
+	logDroppedTotal = newCounterVec("yabt_log_dropped_total",
+		"Log entries that could not be delivered to a log sink, by sink and reason.", "sink", "reason")
+	logSpooledTotal = newCounterVec("yabt_log_spooled_total",
+		"Log entries spooled to disk while a log sink was unavailable, by sink.", "sink")
+
+	jobRunsTotal = newCounterVec("yabt_job_runs_total",
+		"Background data job runs, by job and result.", "job", "result")
+	jobRunDuration = newHistogramVec("yabt_job_run_duration_seconds",
+		"Background data job run time, by job.", outboundLatencyBuckets, "job")
+	scheduledTransactionsPostedTotal = newCounterVec("yabt_scheduled_transactions_posted_total",
+		"Transactions posted from schedules.")
+	netWorthSnapshotsWrittenTotal = newCounterVec("yabt_net_worth_snapshots_written_total",
+		"Net worth snapshots written, including backfilled history.")
+	monthlyBudgetsCreatedTotal = newCounterVec("yabt_monthly_budgets_created_total",
+		"Category rows created by the month rollover.")
+
+	tracesDroppedTotal = newCounterVec("yabt_traces_dropped_spans_total",
+		"Finished spans dropped because their export failed.")
+
+	_ = newGaugeFunc("yabt_build_info", "Build information; the value is always 1.",
+		[]string{"version", "commit", "goversion"}, func() ([]string, float64) {
+			return []string{version, buildCommit(), runtime.Version()}, 1
+		})
+	_ = newGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.",
+		nil, func() ([]string, float64) {
+			return nil, float64(startTime.Unix())
+		})
+)
+
+// observeOutbound records the latency and result of an upstream call.
+func observeOutbound(service string, start time.Time, ok bool) {
+	result := "success"
+	if !ok {
+		result = "error"
+	}
+	outboundRequestsTotal.inc(service, result)
+	outboundRequestDuration.observe(time.Since(start).Seconds(), service)
+}
+
+// routeLabel returns the mux pattern that will serve r so metric labels stay
+// bounded; everything handled by the SPA falls under "/".
+func routeLabel(h http.Handler, r *http.Request) string {
+	if mux, ok := h.(*http.ServeMux); ok {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern
+		}
+	}
+	return "other"
+}
+
+// methodLabel returns the request method for metric labels and span names,
+// with anything but the standard methods folded into "OTHER" so clients
+// can't create a series per made-up method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// hasMetricsToken reports whether r carries METRICS_TOKEN. It is always
+// false when no token is configured.
+func (a *app) hasMetricsToken(r *http.Request) bool {
+	token := a.cfg.MetricsToken
+	return token != "" && subtle.ConstantTimeCompare([]byte(getAPIKeyFromRequest(r)), []byte(token)) == 1
+}
+
+// Metrics handler, optionally protected by METRICS_TOKEN
+func (a *app) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.cfg.MetricsToken != "" && !a.hasMetricsToken(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.writeTo(w)
+}
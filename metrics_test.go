@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"GET", "GET"},
+		{"HEAD", "HEAD"},
+		{"POST", "POST"},
+		{"PUT", "PUT"},
+		{"PATCH", "PATCH"},
+		{"DELETE", "DELETE"},
+		{"OPTIONS", "OPTIONS"},
+		{"get", "OTHER"},
+		{"TRACE", "OTHER"},
+		{"CONNECT", "OTHER"},
+		{"PROPFIND", "OTHER"},
+		{"X-RANDOM-12345", "OTHER"},
+		{"", "OTHER"},
+	}
+	for _, tt := range tests {
+		if got := methodLabel(tt.method); got != tt.want {
+			t.Errorf("methodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
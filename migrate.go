@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Schema migrations. supabase/schema.sql and schema_advanced.sql form the
+// baseline for a new database; dated files in supabase/migrations are
+// applied after it, in version order, and recorded in schema_migrations.
+// A migration may have a <version>_<name>.down.sql file that undoes it.
+//
+// Migrations run after the current schema.sql on new databases, so they
+// must tolerate objects that schema.sql already creates (IF NOT EXISTS,
+// DROP ... IF EXISTS before CREATE).
+
+//go:embed supabase/schema.sql supabase/schema_advanced.sql supabase/migrations/*.sql
+var embeddedSchema embed.FS
+
+type migrateConfig struct {
+	// Postgres connection string for schema changes. On Supabase this is
+	// the database URI from Settings > Database, not the REST URL.
+	SupabaseDBURL string
+	// Apply pending migrations before the server starts listening
+	OnStart bool
+}
+
+func loadMigrateConfig(src *configSource) migrateConfig {
+	return migrateConfig{
+		SupabaseDBURL: src.getEnv("SUPABASE_DB_URL", ""),
+		OnStart:       src.getEnvBool("MIGRATE_ON_START", false),
+	}
+}
+
+const (
+	baselineVersion = "00000000"
+	// Serializes runners across replicas starting at the same time
+	migrationLockID = 4_252_017_046
+)
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_-]+?)(\.down)?\.sql$`)
+
+var errMigrationDrift = errors.New("applied migrations differ from this build")
+
+type migration struct {
+	version  string
+	name     string
+	up       string
+	down     string // empty when the migration cannot be rolled back
+	checksum string // of up
+}
+
+// loadMigrations reads the baseline and migrations from fsys, laid out like
+// the supabase directory.
+func loadMigrations(fsys fs.FS) ([]*migration, error) {
+	var baseline strings.Builder
+	for _, name := range []string{"schema.sql", "schema_advanced.sql"} {
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&baseline, "-- %s\n\n%s\n", name, raw)
+	}
+	migrations := []*migration{{version: baselineVersion, name: "baseline", up: baseline.String()}}
+
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[string]*migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations/%s: expected <version>_<name>.sql", entry.Name())
+		}
+		raw, err := fs.ReadFile(fsys, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		version, name, isDown := match[1], match[2], match[3] != ""
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		} else if m.name != name {
+			return nil, fmt.Errorf("migrations: version %s is used by both %s and %s", version, m.name, name)
+		}
+		if isDown {
+			m.down = string(raw)
+		} else {
+			m.up = string(raw)
+		}
+	}
+
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migrations: %s_%s.down.sql has no matching up migration", m.version, m.name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	for _, m := range migrations {
+		sum := sha256.Sum256([]byte(m.up))
+		m.checksum = hex.EncodeToString(sum[:])
+	}
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	version   string
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// migrator applies migrations over a single connection holding the
+// migration lock.
+type migrator struct {
+	conn       *sql.Conn
+	migrations []*migration
+	applied    map[string]appliedMigration
+}
+
+func openMigrator(ctx context.Context, db *sql.DB, migrations []*migration) (*migrator, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m := &migrator{conn: conn, migrations: migrations}
+
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, migrationLockID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := m.loadApplied(ctx); err != nil {
+		m.close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *migrator) close() {
+	m.conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, migrationLockID)
+	m.conn.Close()
+}
+
+// loadApplied reads schema_migrations, which is only created once a
+// migration is applied, so dry runs and status leave the database alone.
+func (m *migrator) loadApplied(ctx context.Context) error {
+	m.applied = map[string]appliedMigration{}
+
+	var exists bool
+	if err := m.conn.QueryRowContext(ctx, `select to_regclass('schema_migrations') is not null`).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	rows, err := m.conn.QueryContext(ctx, `select version, name, checksum, applied_at from schema_migrations`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return err
+		}
+		m.applied[a.version] = a
+	}
+	return rows.Err()
+}
+
+// drift lists applied migrations whose SQL has changed since they ran, and
+// applied versions this build does not know. The baseline is exempt:
+// schema.sql keeps changing to describe new databases.
+func (m *migrator) drift() []string {
+	known := map[string]bool{}
+	var problems []string
+	for _, mig := range m.migrations {
+		known[mig.version] = true
+		a, ok := m.applied[mig.version]
+		if ok && mig.version != baselineVersion && a.checksum != mig.checksum {
+			problems = append(problems, fmt.Sprintf("%s_%s was changed after it was applied", mig.version, mig.name))
+		}
+	}
+	for version, a := range m.applied {
+		if !known[version] {
+			problems = append(problems, fmt.Sprintf("%s_%s is applied but not part of this build", version, a.name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func (m *migrator) pending() []*migration {
+	var pending []*migration
+	for _, mig := range m.migrations {
+		if _, ok := m.applied[mig.version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending
+}
+
+// hasExistingSchema reports whether the YABT tables were created before
+// migrations were tracked, by the SQL editor or the old scripts.
+func (m *migrator) hasExistingSchema(ctx context.Context) (bool, error) {
+	var exists bool
+	err := m.conn.QueryRowContext(ctx, `select to_regclass('public.budgets') is not null`).Scan(&exists)
+	return exists, err
+}
+
+func (m *migrator) createTable(ctx context.Context) error {
+	_, err := m.conn.ExecContext(ctx,
+		`create table if not exists schema_migrations (
+		   version text primary key,
+		   name text not null,
+		   checksum text not null,
+		   applied_at timestamptz not null default now()
+		 )`)
+	return err
+}
+
+// record runs sql, when given, and records the migration in one transaction.
+func (m *migrator) record(ctx context.Context, mig *migration, sqlText string) error {
+	if err := m.createTable(ctx); err != nil {
+		return err
+	}
+
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if sqlText != "" {
+		// Without arguments the statements run over the simple protocol, so
+		// a file may hold many statements and dollar-quoted functions
+		if _, err := tx.ExecContext(ctx, sqlText); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`insert into schema_migrations (version, name, checksum) values ($1, $2, $3)`,
+		mig.version, mig.name, mig.checksum,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *migrator) revert(ctx context.Context, mig *migration) error {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, mig.down); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from schema_migrations where version = $1`, mig.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type migrateOptions struct {
+	dryRun bool
+	fake   bool   // record pending migrations without running them
+	target string // stop after this version; empty for all
+	steps  int    // down: how many to roll back
+}
+
+// up applies pending migrations in order, reporting each on report.
+func (m *migrator) up(ctx context.Context, opts migrateOptions, report func(format string, args ...interface{})) (int, error) {
+	if problems := m.drift(); len(problems) > 0 {
+		return 0, fmt.Errorf("%w:\n  %s", errMigrationDrift, strings.Join(problems, "\n  "))
+	}
+
+	applied := 0
+	for _, mig := range m.pending() {
+		if opts.target != "" && mig.version > opts.target {
+			break
+		}
+		label := mig.version + "_" + mig.name
+
+		sqlText := mig.up
+		action := "Applied"
+		if mig.version == baselineVersion {
+			existing, err := m.hasExistingSchema(ctx)
+			if err != nil {
+				return applied, err
+			}
+			if existing {
+				// Created by hand before migrations were tracked
+				sqlText, action = "", "Recorded existing"
+			}
+		}
+		if opts.fake {
+			sqlText, action = "", "Recorded"
+		}
+
+		if opts.dryRun {
+			if sqlText == "" {
+				report("Would record %s as applied", label)
+			} else {
+				report("Would apply %s\n\n%s\n", label, strings.TrimSpace(sqlText))
+			}
+			applied++
+			continue
+		}
+
+		start := time.Now()
+		if err := m.record(ctx, mig, sqlText); err != nil {
+			return applied, fmt.Errorf("%s: %w", label, err)
+		}
+		report("%s %s (%s)", action, label, time.Since(start).Round(time.Millisecond))
+		applied++
+	}
+	return applied, nil
+}
+
+// down rolls back the latest applied migrations: opts.steps of them, or
+// every one after opts.target.
+func (m *migrator) down(ctx context.Context, opts migrateOptions, report func(format string, args ...interface{})) (int, error) {
+	var applied []*migration
+	for _, mig := range m.migrations {
+		if _, ok := m.applied[mig.version]; ok {
+			applied = append(applied, mig)
+		}
+	}
+
+	reverted := 0
+	for i := len(applied) - 1; i >= 0; i-- {
+		mig := applied[i]
+		if opts.target != "" && mig.version <= opts.target {
+			break
+		}
+		if opts.target == "" && reverted >= opts.steps {
+			break
+		}
+		label := mig.version + "_" + mig.name
+		if mig.version == baselineVersion {
+			return reverted, errors.New("the baseline schema cannot be rolled back")
+		}
+		if mig.down == "" {
+			return reverted, fmt.Errorf("%s has no down migration", label)
+		}
+		if m.applied[mig.version].checksum != mig.checksum {
+			return reverted, fmt.Errorf("%w: %s was changed after it was applied", errMigrationDrift, label)
+		}
+
+		if opts.dryRun {
+			report("Would roll back %s\n\n%s\n", label, strings.TrimSpace(mig.down))
+			reverted++
+			continue
+		}
+		if err := m.revert(ctx, mig); err != nil {
+			return reverted, fmt.Errorf("%s: %w", label, err)
+		}
+		report("Rolled back %s", label)
+		reverted++
+	}
+	return reverted, nil
+}
+
+// migrationDSN returns the connection string for migrations.
+func (c *Config) migrationDSN() string {
+	return firstNonEmpty(c.Migrate.SupabaseDBURL, c.Storage.DatabaseURL)
+}
+
+// runStartupMigrations applies pending migrations before the server starts,
+// when MIGRATE_ON_START is set. Drift or a failed migration stops startup.
+func runStartupMigrations(ctx context.Context, dsn string) error {
+	if dsn == "" {
+		return errors.New("MIGRATE_ON_START needs SUPABASE_DB_URL or DATABASE_URL")
+	}
+	migrations, err := loadMigrations(embeddedSchemaFS())
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := openMigrator(ctx, db, migrations)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	n, err := m.up(ctx, migrateOptions{}, func(format string, args ...interface{}) {
+		logger.Info("Migration", "step", fmt.Sprintf(format, args...))
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Database schema up to date", "applied", n, "version", m.migrations[len(m.migrations)-1].version)
+	return nil
+}
+
+func embeddedSchemaFS() fs.FS {
+	fsys, _ := fs.Sub(embeddedSchema, "supabase")
+	return fsys
+}
+
+// migrateCommand applies, rolls back or lists migrations.
+func migrateCommand(cfg *Config, args []string) error {
+	flags := newFlagSet("migrate", "[up|down|status]")
+	dbURL := flags.String("database-url", "", "Postgres connection string (default SUPABASE_DB_URL, then DATABASE_URL)")
+	dir := flags.String("dir", "", "read SQL from this directory, laid out like supabase/, instead of the copy built in")
+	dryRun := flags.Bool("dry-run", false, "print the SQL that would run without changing anything")
+	fake := flags.Bool("fake", false, "up: record pending migrations as applied without running them")
+	target := flags.String("to", "", "up: stop after this version; down: roll back everything after it")
+	steps := flags.Int("steps", 1, "down: number of migrations to roll back")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	action := "up"
+	if len(positional) > 0 {
+		action = positional[0]
+	}
+	if len(positional) > 1 || (action != "up" && action != "down" && action != "status") {
+		return usageError(flags, "expected up, down or status")
+	}
+
+	fsys := embeddedSchemaFS()
+	if *dir != "" {
+		fsys = os.DirFS(*dir)
+	}
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return err
+	}
+
+	dsn := firstNonEmpty(*dbURL, cfg.migrationDSN())
+	if dsn == "" && *dryRun && action == "up" {
+		// Everything a new database would get, e.g. for the SQL editor
+		for _, mig := range migrations {
+			if *target != "" && mig.version > *target {
+				break
+			}
+			fmt.Printf("-- %s_%s\n\n%s\n\n", mig.version, mig.name, strings.TrimSpace(mig.up))
+		}
+		return nil
+	}
+	if dsn == "" {
+		return usageError(flags, "set SUPABASE_DB_URL or DATABASE_URL, or pass --database-url")
+	}
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := openMigrator(ctx, db, migrations)
+	if err != nil {
+		return err
+	}
+	defer m.close()
+
+	report := func(format string, args ...interface{}) { fmt.Printf(format+"\n", args...) }
+	opts := migrateOptions{dryRun: *dryRun, fake: *fake, target: *target, steps: *steps}
+
+	switch action {
+	case "status":
+		return printMigrationStatus(m)
+	case "down":
+		n, err := m.down(ctx, opts, report)
+		if err == nil && n == 0 {
+			fmt.Println("Nothing to roll back")
+		}
+		return err
+	default:
+		n, err := m.up(ctx, opts, report)
+		if err == nil && n == 0 {
+			fmt.Println("Database schema is up to date")
+		}
+		return err
+	}
+}
+
+func printMigrationStatus(m *migrator) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+	for _, mig := range m.migrations {
+		status := "pending"
+		if a, ok := m.applied[mig.version]; ok {
+			status = "applied " + a.appliedAt.UTC().Format(time.RFC3339)
+			if mig.version != baselineVersion && a.checksum != mig.checksum {
+				status += " (changed since)"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", mig.version, mig.name, status)
+	}
+	known := map[string]bool{}
+	for _, mig := range m.migrations {
+		known[mig.version] = true
+	}
+	for version, a := range m.applied {
+		if !known[version] {
+			fmt.Fprintf(tw, "%s\t%s\tapplied, not in this build\n", version, a.name)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if problems := m.drift(); len(problems) > 0 {
+		return fmt.Errorf("%w:\n  %s", errMigrationDrift, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Net worth snapshots are kept by a data job: one row per budget per
+// period, dated the first day of the period and refreshed on every run
+// until the period ends, so each row ends up holding the closing balance.
+// A budget without any snapshots gets its history rebuilt from
+// transactions first.
+type netWorthConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	Period   string // daily, weekly or monthly
+}
+
+func loadNetWorthConfig(src *configSource) netWorthConfig {
+	return netWorthConfig{
+		Enabled:  src.getEnvBool("NET_WORTH_SNAPSHOTS", true),
+		Interval: src.getEnvDuration("NET_WORTH_SNAPSHOTS_INTERVAL", time.Hour),
+		Period:   src.getEnvEnum("NET_WORTH_SNAPSHOT_PERIOD", "monthly", "daily", "weekly", "monthly"),
+	}
+}
+
+// Account types whose balances are owed rather than owned. Every other
+// type, tracking accounts included, counts towards assets whether or not
+// it is on budget.
+var liabilityAccountTypes = map[string]bool{
+	"credit_card": true,
+	"loan":        true,
+}
+
+// runNetWorthSnapshots snapshots every budget with open accounts. Closed
+// accounts are left out of both the current snapshot and the backfill.
+func runNetWorthSnapshots(ctx context.Context, store dataStore, today time.Time, cfg netWorthConfig) error {
+	accounts, err := store.listAllOpenAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var budgetIDs []string
+	accountsByBudget := make(map[string][]accountRecord)
+	for _, account := range accounts {
+		if _, ok := accountsByBudget[account.BudgetID]; !ok {
+			budgetIDs = append(budgetIDs, account.BudgetID)
+		}
+		accountsByBudget[account.BudgetID] = append(accountsByBudget[account.BudgetID], account)
+	}
+
+	current := periodStart(today, cfg.Period)
+	failed := 0
+	for _, budgetID := range budgetIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		written, err := snapshotBudgetNetWorth(ctx, store, budgetID, accountsByBudget[budgetID], current, cfg.Period)
+		if err != nil {
+			failed++
+			logger.Error("Failed to snapshot net worth", "budgetId", budgetID, "error", err)
+			continue
+		}
+		netWorthSnapshotsWrittenTotal.add(float64(written))
+		if written > 1 {
+			logger.Info("Backfilled net worth history", "budgetId", budgetID, "snapshots", written)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("net worth snapshots failed for %d of %d budgets", failed, len(budgetIDs))
+	}
+	return nil
+}
+
+// snapshotBudgetNetWorth upserts the snapshot for the current period, plus
+// every earlier period back to the first transaction when the budget has
+// no snapshots yet. It returns the number of snapshots written.
+func snapshotBudgetNetWorth(ctx context.Context, store dataStore, budgetID string, accounts []accountRecord, current time.Time, period string) (int, error) {
+	balances := make(map[string]float64, len(accounts))
+	types := make(map[string]string, len(accounts))
+	for _, account := range accounts {
+		balances[account.ID] = account.Balance
+		types[account.ID] = account.AccountType
+	}
+	snapshots := []netWorthSnapshot{newNetWorthSnapshot(budgetID, current, balances, types)}
+
+	hasHistory, err := store.hasNetWorthSnapshots(ctx, budgetID)
+	if err != nil {
+		return 0, err
+	}
+	if !hasHistory {
+		amounts, err := store.listTransactionAmounts(ctx, budgetID)
+		if err != nil {
+			return 0, err
+		}
+		snapshots = append(snapshots, backfillNetWorth(budgetID, balances, types, amounts, current, period)...)
+	}
+
+	if err := store.upsertNetWorthSnapshots(ctx, snapshots); err != nil {
+		return 0, err
+	}
+	return len(snapshots), nil
+}
+
+// backfillNetWorth winds the current balances back through amounts
+// (newest first) to find the closing balances of each period before
+// current, back to the period of the oldest transaction in an open
+// account.
+func backfillNetWorth(budgetID string, balances map[string]float64, types map[string]string, amounts []transactionAmount, current time.Time, period string) []netWorthSnapshot {
+	oldest := ""
+	for _, amount := range amounts {
+		if _, ok := balances[amount.AccountID]; ok {
+			oldest = amount.Date
+		}
+	}
+	oldestDate, err := time.Parse(dateLayout, oldest)
+	if err != nil {
+		return nil
+	}
+	first := periodStart(oldestDate, period)
+
+	running := make(map[string]float64, len(balances))
+	for id, balance := range balances {
+		running[id] = balance
+	}
+
+	var snapshots []netWorthSnapshot
+	next := 0
+	end := current
+	for start := previousPeriod(current, period); !start.Before(first); start = previousPeriod(start, period) {
+		// Undo everything dated on or after the end of this period
+		cutoff := end.Format(dateLayout)
+		for ; next < len(amounts) && amounts[next].Date >= cutoff; next++ {
+			if _, ok := running[amounts[next].AccountID]; ok {
+				running[amounts[next].AccountID] -= amounts[next].Amount
+			}
+		}
+		snapshots = append(snapshots, newNetWorthSnapshot(budgetID, start, running, types))
+		end = start
+	}
+	return snapshots
+}
+
+func newNetWorthSnapshot(budgetID string, date time.Time, balances map[string]float64, types map[string]string) netWorthSnapshot {
+	var assets, liabilities float64
+	for id, balance := range balances {
+		switch {
+		case !liabilityAccountTypes[types[id]]:
+			assets += balance
+		case balance < 0:
+			liabilities -= balance
+		default:
+			// A credit card paid beyond its balance is money owed to you
+			assets += balance
+		}
+	}
+	assets, liabilities = roundCents(assets), roundCents(liabilities)
+	return netWorthSnapshot{
+		BudgetID:    budgetID,
+		Date:        date.Format(dateLayout),
+		Assets:      assets,
+		Liabilities: liabilities,
+		NetWorth:    roundCents(assets - liabilities),
+	}
+}
+
+// periodStart returns the first day of the period containing date. Weeks
+// start on Monday.
+func periodStart(date time.Time, period string) time.Time {
+	switch period {
+	case "daily":
+		return date
+	case "weekly":
+		return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+	}
+	return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// previousPeriod returns the start of the period before the one starting
+// at start.
+func previousPeriod(start time.Time, period string) time.Time {
+	switch period {
+	case "daily":
+		return start.AddDate(0, 0, -1)
+	case "weekly":
+		return start.AddDate(0, 0, -7)
+	}
+	return start.AddDate(0, -1, 0)
+}
+
+func roundCents(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		date   string
+		period string
+		want   string
+	}{
+		{"2026-10-18", "daily", "2026-10-18"},
+		{"2026-10-18", "weekly", "2026-10-12"}, // Sunday
+		{"2026-10-12", "weekly", "2026-10-12"}, // Monday
+		{"2026-11-01", "weekly", "2026-10-26"}, // week starting in the previous month
+		{"2027-01-02", "weekly", "2026-12-28"}, // and the previous year
+		{"2026-10-18", "monthly", "2026-10-01"},
+		{"2026-10-01", "monthly", "2026-10-01"},
+	}
+	for _, tt := range tests {
+		got := periodStart(mustDate(t, tt.date), tt.period).Format(dateLayout)
+		if got != tt.want {
+			t.Errorf("periodStart(%s, %s) = %s, want %s", tt.date, tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestPreviousPeriod(t *testing.T) {
+	tests := []struct {
+		start  string
+		period string
+		want   string
+	}{
+		{"2026-03-01", "daily", "2026-02-28"},
+		{"2026-11-02", "weekly", "2026-10-26"},
+		{"2026-01-01", "monthly", "2025-12-01"},
+	}
+	for _, tt := range tests {
+		got := previousPeriod(mustDate(t, tt.start), tt.period).Format(dateLayout)
+		if got != tt.want {
+			t.Errorf("previousPeriod(%s, %s) = %s, want %s", tt.start, tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestBackfillNetWorth(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[string]float64
+		types    map[string]string
+		amounts  []transactionAmount // newest first
+		current  string
+		period   string
+		want     []netWorthSnapshot
+	}{
+		{
+			name:     "monthly with a credit card",
+			balances: map[string]float64{"checking": 1000, "card": -200},
+			types:    map[string]string{"checking": "checking", "card": "credit_card"},
+			amounts: []transactionAmount{
+				{AccountID: "checking", Date: "2026-10-05", Amount: 100},
+				{AccountID: "card", Date: "2026-09-20", Amount: -50},
+				{AccountID: "checking", Date: "2026-09-10", Amount: -300},
+				{AccountID: "checking", Date: "2026-08-15", Amount: 500},
+			},
+			current: "2026-10-01",
+			period:  "monthly",
+			want: []netWorthSnapshot{
+				{BudgetID: "b", Date: "2026-09-01", Assets: 900, Liabilities: 200, NetWorth: 700},
+				{BudgetID: "b", Date: "2026-08-01", Assets: 1200, Liabilities: 150, NetWorth: 1050},
+			},
+		},
+		{
+			name:     "weekly across a month boundary",
+			balances: map[string]float64{"checking": 500},
+			types:    map[string]string{"checking": "checking"},
+			amounts: []transactionAmount{
+				{AccountID: "checking", Date: "2026-11-03", Amount: 50},
+				{AccountID: "checking", Date: "2026-10-30", Amount: -20},
+				{AccountID: "checking", Date: "2026-10-26", Amount: 10},
+				{AccountID: "checking", Date: "2026-10-20", Amount: 100},
+			},
+			current: "2026-11-02",
+			period:  "weekly",
+			want: []netWorthSnapshot{
+				{BudgetID: "b", Date: "2026-10-26", Assets: 450, NetWorth: 450},
+				{BudgetID: "b", Date: "2026-10-19", Assets: 460, NetWorth: 460},
+			},
+		},
+		{
+			name:     "closed accounts neither count nor extend the history",
+			balances: map[string]float64{"checking": 100},
+			types:    map[string]string{"checking": "checking"},
+			amounts: []transactionAmount{
+				{AccountID: "checking", Date: "2026-09-15", Amount: 40},
+				{AccountID: "closed", Date: "2026-09-01", Amount: 1000},
+				{AccountID: "closed", Date: "2025-01-01", Amount: 1000},
+			},
+			current: "2026-10-01",
+			period:  "monthly",
+			want: []netWorthSnapshot{
+				{BudgetID: "b", Date: "2026-09-01", Assets: 100, NetWorth: 100},
+			},
+		},
+		{
+			name:     "only transactions in the current period",
+			balances: map[string]float64{"checking": 100},
+			types:    map[string]string{"checking": "checking"},
+			amounts: []transactionAmount{
+				{AccountID: "checking", Date: "2026-10-02", Amount: 100},
+			},
+			current: "2026-10-01",
+			period:  "monthly",
+		},
+		{
+			name:     "no transactions",
+			balances: map[string]float64{"checking": 100},
+			types:    map[string]string{"checking": "checking"},
+			current:  "2026-10-01",
+			period:   "monthly",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := backfillNetWorth("b", tt.balances, tt.types, tt.amounts, mustDate(t, tt.current), tt.period)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Per-route deadlines. Shortcut calls wait on both the AI parser and several
+// storage round trips, so they get a little more headroom than the proxies.
+const (
+	aiChatDeadline     = 60 * time.Second
+	transcribeDeadline = 60 * time.Second
+	shortcutDeadline   = 75 * time.Second
+)
+
+type requestIDKey struct{}
+
+// withRequestID stores the request ID in ctx for outbound calls and logging.
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// requestIDFromContext returns the request ID set by loggingMiddleware, if any.
+func requestIDFromContext(ctx context.Context) string {
+	if requestID, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return requestID
+	}
+	return ""
+}
+
+// newOutboundRequest creates an HTTP request bound to ctx and forwards the
+// inbound request ID and trace context so upstream logs can be correlated
+// with ours.
+func newOutboundRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+	injectTraceparent(ctx, req.Header)
+	return req, nil
+}
+
+// withDeadline bounds the request context of a route, so outbound calls made
+// on its behalf are abandoned once the deadline passes or the client leaves.
+func withDeadline(timeout time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
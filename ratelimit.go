@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a keyed token bucket: each key may spend up to burst
+// tokens at once, refilled at perMinute tokens per minute. A perMinute of
+// zero disables the limit.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// allow spends n tokens for key. When the bucket is short it spends nothing
+// and returns how long until n tokens are available.
+func (l *rateLimiter) allow(key string, n int) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	cost := float64(n)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens >= cost {
+		bucket.tokens -= cost
+		return true, 0
+	}
+	if cost > l.burst {
+		return false, time.Minute
+	}
+	wait := time.Duration((cost - bucket.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets buckets that have refilled completely, so idle clients do
+// not accumulate. Callers hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		spend     []int
+		want      []bool
+	}{
+		{"burst then refuse", 60, 3, []int{1, 1, 1, 1}, []bool{true, true, true, false}},
+		{"batch within burst", 60, 50, []int{50, 1}, []bool{true, false}},
+		{"batch above burst", 60, 10, []int{11}, []bool{false}},
+		{"refused batch spends nothing", 60, 10, []int{8, 5, 2}, []bool{true, false, true}},
+		{"zero disables the limit", 0, 1, []int{100, 100, 100}, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.perMinute, tt.burst)
+			for i, n := range tt.spend {
+				ok, wait := l.allow("k", n)
+				if ok != tt.want[i] {
+					t.Fatalf("allow #%d (%d tokens) = %v, want %v", i+1, n, ok, tt.want[i])
+				}
+				if !ok && wait <= 0 {
+					t.Errorf("allow #%d refused without a wait", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeysAreIndependent(t *testing.T) {
+	l := newRateLimiter(60, 1)
+	if ok, _ := l.allow("a", 1); !ok {
+		t.Fatal("first request for a refused")
+	}
+	if ok, _ := l.allow("b", 1); !ok {
+		t.Fatal("first request for b refused after a spent its burst")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Default key patterns, matched case-insensitively against map keys, query
+// parameter names and structured log attributes. Shortcut text and memos
+// carry amounts and merchants, so they are treated like credentials.
+const (
+	defaultRedactKeys = `password|passwd|secret|token|api_?key|authorization|cookie|session|^text$|^memo$|^notes?$|^transcript$`
+	defaultHashKeys   = `^(email|user|user_?id|payee|payee_?name)$`
+)
+
+// Keys that privacy mode always drops: anything describing money movement.
+var financialKeyRegex = regexp.MustCompile(`(?i)^(amount|balance|payee|payee_?name|payee_?id|category|category_?id|requestedcategory|account|account_?id|memo|text|transcript|parsed|transactions?)$`)
+
+// Value detectors selectable with LOG_REDACT_VALUES, applied in
+// redactDetectorOrder so IBANs are masked whole before the card detector
+// sees their digits.
+var redactDetectorOrder = []string{"email", "iban", "card", "amount"}
+
+var redactValueDetectors = map[string]*regexp.Regexp{
+	"email":  regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	"card":   regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+	"iban":   regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`),
+	"amount": regexp.MustCompile(`(?i)(?:[$€£¥₹]\s?-?\d[\d,]*(?:\.\d+)?|-?\d[\d,]*(?:\.\d+)?\s?(?:usd|eur|gbp|inr|jpy|cad|aud)\b)`),
+}
+
+// redactConfig is the LOG_REDACT_*, LOG_HASH_*, LOG_IP_MODE and
+// LOG_PRIVACY_MODE settings.
+type redactConfig struct {
+	Keys      *regexp.Regexp // nil redacts no keys
+	HashKeys  *regexp.Regexp // nil hashes no keys
+	Detectors []string       // from redactValueDetectors
+	IPMode    string
+	Salt      string
+	Privacy   bool
+}
+
+func loadRedactConfig(src *configSource) redactConfig {
+	cfg := redactConfig{
+		Keys:     loadKeyPattern(src, "LOG_REDACT_KEYS", defaultRedactKeys),
+		HashKeys: loadKeyPattern(src, "LOG_HASH_KEYS", defaultHashKeys),
+		IPMode:   src.getEnvEnum("LOG_IP_MODE", "truncate", "full", "truncate", "hash", "drop"),
+		Salt:     src.getEnv("LOG_HASH_SALT", ""),
+		Privacy:  src.getEnvBool("LOG_PRIVACY_MODE", false),
+	}
+	// Without a configured salt, hashes still correlate within one run.
+	// Picked here so every policy built from cfg hashes alike.
+	if cfg.Salt == "" {
+		random := make([]byte, 32)
+		rand.Read(random)
+		cfg.Salt = hex.EncodeToString(random)
+	}
+	for _, name := range strings.Split(src.getEnv("LOG_REDACT_VALUES", "email,card,iban,amount"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		if _, ok := redactValueDetectors[name]; !ok {
+			src.invalid("LOG_REDACT_VALUES", "unknown detector %q", name)
+			continue
+		}
+		cfg.Detectors = append(cfg.Detectors, name)
+	}
+	return cfg
+}
+
+// loadKeyPattern compiles a key pattern setting, falling back to the
+// default when it is invalid.
+func loadKeyPattern(src *configSource, name, fallback string) *regexp.Regexp {
+	pattern := src.getEnv(name, fallback)
+	if pattern == "" || pattern == "none" {
+		return nil
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		src.invalid(name, "invalid pattern: %v", err)
+		return regexp.MustCompile("(?i)" + fallback)
+	}
+	return re
+}
+
+// redactionPolicy decides what of a log entry may leave the process.
+// Sensitive keys are replaced, correlatable keys (users, payees) are hashed
+// so the same value can still be followed across entries, and free text is
+// scanned for emails, card numbers, IBANs and amounts.
+//
+// Privacy mode goes further and never logs financial payloads: bodies,
+// query strings, referrers, IPs and transaction attributes are dropped
+// entirely.
+type redactionPolicy struct {
+	keys      *regexp.Regexp
+	hashKeys  *regexp.Regexp
+	detectors []*regexp.Regexp
+	ipMode    string
+	salt      []byte
+	privacy   bool
+}
+
+func newRedactionPolicy(cfg redactConfig) *redactionPolicy {
+	p := &redactionPolicy{
+		keys:     cfg.Keys,
+		hashKeys: cfg.HashKeys,
+		ipMode:   cfg.IPMode,
+		privacy:  cfg.Privacy,
+	}
+
+	enabled := map[string]bool{}
+	for _, name := range cfg.Detectors {
+		enabled[name] = true
+	}
+	for _, name := range redactDetectorOrder {
+		if enabled[name] {
+			p.detectors = append(p.detectors, redactValueDetectors[name])
+		}
+	}
+
+	// Only policies not built from the configuration lack a salt
+	if cfg.Salt == "" {
+		random := make([]byte, 32)
+		rand.Read(random)
+		p.salt = random
+	} else {
+		p.salt = []byte(cfg.Salt)
+	}
+
+	return p
+}
+
+// apply redacts an entry in place before it is written or shipped.
+func (p *redactionPolicy) apply(entry *LogEntry) {
+	if p.privacy {
+		entry.Body = nil
+	}
+	entry.Body = p.value("", entry.Body)
+	entry.User = p.scalar("user", entry.User)
+	entry.IP = p.ip(entry.IP)
+	entry.Referer = p.referer(entry.Referer)
+	entry.Message = p.text(entry.Message)
+	entry.Error = p.text(entry.Error)
+
+	for key, value := range entry.Fields {
+		if p.privacy && matchKey(financialKeyRegex, key) {
+			delete(entry.Fields, key)
+			continue
+		}
+		entry.Fields[key] = p.value(key, value)
+	}
+}
+
+// value redacts v found under key, walking nested objects and arrays.
+func (p *redactionPolicy) value(key string, v interface{}) interface{} {
+	if key != "" {
+		if p.keys != nil && matchKey(p.keys, key) {
+			return redactedValue
+		}
+		if p.hashKeys != nil && matchKey(p.hashKeys, key) {
+			switch v.(type) {
+			case map[string]interface{}, []interface{}, nil:
+			default:
+				return p.hash(fmt.Sprint(v))
+			}
+		}
+	}
+
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for k, nested := range typed {
+			if p.privacy && matchKey(financialKeyRegex, k) {
+				continue
+			}
+			result[k] = p.value(k, nested)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i, nested := range typed {
+			// Array elements inherit the key of the array they belong to
+			result[i] = p.value(key, nested)
+		}
+		return result
+	case string:
+		return p.text(typed)
+	default:
+		return v
+	}
+}
+
+// matchKey reports whether re matches key or, for attributes logged in a
+// group ("meta.amount"), its last segment, so anchored patterns still
+// catch grouped keys.
+func matchKey(re *regexp.Regexp, key string) bool {
+	if re.MatchString(key) {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		return re.MatchString(key[i+1:])
+	}
+	return false
+}
+
+// scalar redacts a string field that is always logged under key.
+func (p *redactionPolicy) scalar(key, v string) string {
+	if v == "" {
+		return v
+	}
+	if s, ok := p.value(key, v).(string); ok {
+		return s
+	}
+	return redactedValue
+}
+
+// text masks values recognised by the enabled detectors.
+func (p *redactionPolicy) text(s string) string {
+	for _, detector := range p.detectors {
+		s = detector.ReplaceAllString(s, redactedValue)
+	}
+	return s
+}
+
+func (p *redactionPolicy) hash(v string) string {
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write([]byte(v))
+	return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// ip applies LOG_IP_MODE to a client address, which may carry a port or be
+// a comma-separated forwarding chain.
+func (p *redactionPolicy) ip(v string) string {
+	if p.ipMode == "drop" || p.privacy {
+		return ""
+	}
+	if v == "" || p.ipMode == "full" {
+		return v
+	}
+
+	parts := strings.Split(v, ",")
+	for i, part := range parts {
+		host := strings.TrimSpace(part)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		addr := net.ParseIP(host)
+		switch {
+		case addr == nil:
+			parts[i] = redactedValue
+		case p.ipMode == "hash":
+			parts[i] = p.hash(addr.String())
+		case addr.To4() != nil:
+			parts[i] = addr.Mask(net.CIDRMask(24, 32)).String()
+		default:
+			parts[i] = addr.Mask(net.CIDRMask(48, 128)).String()
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// requestPath is the path logged for a request: query values pass through
+// the same key and value rules, and privacy mode drops the query entirely.
+func (p *redactionPolicy) requestPath(u *url.URL) string {
+	if u.RawQuery == "" || p.privacy {
+		return u.Path
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		name, raw, found := strings.Cut(param, "=")
+		if !found {
+			continue
+		}
+		key, err := url.QueryUnescape(name)
+		if err != nil {
+			key = name
+		}
+		value, err := url.QueryUnescape(raw)
+		if err != nil {
+			value = raw
+		}
+
+		redacted := p.scalar(key, value)
+		if redacted != value {
+			params[i] = name + "=" + redacted
+		}
+	}
+	return u.Path + "?" + strings.Join(params, "&")
+}
+
+// referer is the Referer logged for a request: origin and path, with the
+// query redacted like requestPath's. Privacy mode drops it entirely.
+func (p *redactionPolicy) referer(value string) string {
+	if value == "" || p.privacy {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return redactedValue
+	}
+	origin := ""
+	if u.Host != "" {
+		origin = u.Scheme + "://" + u.Host
+	}
+	return origin + p.requestPath(u)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The month rollover job keeps monthly_budgets complete for the current
+// month. It brings last month's activity and available up to date from
+// transactions, works out what each category carries into this month,
+// and creates this month's row for every category that has none. Rows
+// the user has already budgeted keep their budgeted amount; only their
+// carryover, activity and available are refreshed.
+type rolloverConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// Fill budgeted on new rows from each category's target
+	AutoAssign bool
+	// What happens to overspending: "carry" starts the next month
+	// negative, "reset" starts it at zero. Credit card overspending is
+	// already owed on the card, so it resets by default.
+	CashOverspending   string
+	CreditOverspending string
+}
+
+func loadRolloverConfig(src *configSource) rolloverConfig {
+	return rolloverConfig{
+		Enabled:            src.getEnvBool("MONTH_ROLLOVER", true),
+		Interval:           src.getEnvDuration("MONTH_ROLLOVER_INTERVAL", time.Hour),
+		AutoAssign:         src.getEnvBool("MONTH_ROLLOVER_AUTO_ASSIGN", false),
+		CashOverspending:   src.getEnvEnum("MONTH_ROLLOVER_CASH_OVERSPENDING", "carry", "carry", "reset"),
+		CreditOverspending: src.getEnvEnum("MONTH_ROLLOVER_CREDIT_OVERSPENDING", "reset", "carry", "reset"),
+	}
+}
+
+func runMonthRollover(ctx context.Context, store dataStore, today time.Time, cfg rolloverConfig) error {
+	targets, err := store.listCategoryTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	var budgetIDs []string
+	categoriesByBudget := make(map[string][]categoryTarget)
+	for _, target := range targets {
+		if _, ok := categoriesByBudget[target.BudgetID]; !ok {
+			budgetIDs = append(budgetIDs, target.BudgetID)
+		}
+		categoriesByBudget[target.BudgetID] = append(categoriesByBudget[target.BudgetID], target)
+	}
+
+	month := periodStart(today, "monthly")
+	failed := 0
+	for _, budgetID := range budgetIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		created, err := rolloverBudget(ctx, store, cfg, budgetID, categoriesByBudget[budgetID], month)
+		if err != nil {
+			failed++
+			logger.Error("Failed to roll over monthly budget", "budgetId", budgetID, "error", err)
+			continue
+		}
+		if created > 0 {
+			monthlyBudgetsCreatedTotal.add(float64(created))
+			logger.Info("Rolled over monthly budget", "budgetId", budgetID, "month", month.Format(dateLayout), "categories", created)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("month rollover failed for %d of %d budgets", failed, len(budgetIDs))
+	}
+	return nil
+}
+
+// rolloverBudget brings the budget's rows for month and the month before
+// up to date. It returns the number of rows created for month.
+func rolloverBudget(ctx context.Context, store dataStore, cfg rolloverConfig, budgetID string, categories []categoryTarget, month time.Time) (int, error) {
+	prev := month.AddDate(0, -1, 0).Format(dateLayout)
+	current := month.Format(dateLayout)
+
+	rows, err := store.listMonthlyBudgets(ctx, budgetID, []string{prev, current})
+	if err != nil {
+		return 0, err
+	}
+	activity, err := store.listCategoryActivity(ctx, budgetID, prev, month.AddDate(0, 1, 0).Format(dateLayout))
+	if err != nil {
+		return 0, err
+	}
+
+	stored := make(map[string]map[string]monthlyBudgetRecord)
+	for _, row := range rows {
+		if stored[row.Month] == nil {
+			stored[row.Month] = make(map[string]monthlyBudgetRecord)
+		}
+		stored[row.Month][row.CategoryID] = row
+	}
+	spent := make(map[string]map[string]categoryActivity)
+	for _, a := range activity {
+		if spent[a.Month] == nil {
+			spent[a.Month] = make(map[string]categoryActivity)
+		}
+		spent[a.Month][a.CategoryID] = a
+	}
+
+	var created, updated []monthlyBudgetRecord
+	for _, category := range categories {
+		prevRow, hasPrev := stored[prev][category.ID]
+		prevActivity := spent[prev][category.ID]
+		prevRow.CategoryID, prevRow.Month = category.ID, prev
+		prevRow.Activity = roundCents(prevActivity.Activity)
+		prevRow.Available = roundCents(prevRow.Carryover + prevRow.Budgeted + prevRow.Activity)
+		if hasPrev && balancesChanged(stored[prev][category.ID], prevRow) {
+			updated = append(updated, prevRow)
+		}
+
+		carry := cfg.carryover(prevRow.Available, prevActivity.CreditSpending)
+		row, exists := stored[current][category.ID]
+		row.CategoryID, row.Month = category.ID, current
+		row.Carryover = carry
+		row.Activity = roundCents(spent[current][category.ID].Activity)
+		if !exists && cfg.AutoAssign {
+			row.Budgeted = targetAssignment(category, month, carry)
+		}
+		row.Available = roundCents(row.Carryover + row.Budgeted + row.Activity)
+		switch {
+		case !exists:
+			created = append(created, row)
+		case balancesChanged(stored[current][category.ID], row):
+			updated = append(updated, row)
+		}
+	}
+
+	if len(created) > 0 {
+		if err := store.insertMonthlyBudgets(ctx, created); err != nil {
+			return 0, err
+		}
+	}
+	if len(updated) > 0 {
+		if err := store.updateMonthlyBudgetBalances(ctx, updated); err != nil {
+			return len(created), err
+		}
+	}
+	return len(created), nil
+}
+
+func balancesChanged(before, after monthlyBudgetRecord) bool {
+	return roundCents(before.Carryover) != after.Carryover ||
+		roundCents(before.Activity) != after.Activity ||
+		roundCents(before.Available) != after.Available
+}
+
+// carryover returns what a category ending the month at available takes
+// into the next one. Overspending is split into the part charged to credit
+// cards and the rest, paid in cash, and each follows its own setting.
+func (c rolloverConfig) carryover(available, creditSpending float64) float64 {
+	if available >= 0 {
+		return available
+	}
+	overspent := -available
+	credit := math.Min(overspent, creditSpending)
+	cash := overspent - credit
+
+	carry := 0.0
+	if c.CashOverspending == "carry" {
+		carry -= cash
+	}
+	if c.CreditOverspending == "carry" {
+		carry -= credit
+	}
+	return roundCents(carry)
+}
+
+// targetAssignment is what a category's target asks to be budgeted in
+// month, given what it carries in.
+func targetAssignment(category categoryTarget, month time.Time, carry float64) float64 {
+	amount := category.TargetAmount
+	switch category.TargetType {
+	case "monthly_contribution":
+		return roundCents(amount)
+	case "weekly_contribution":
+		return roundCents(amount * float64(mondaysIn(month)))
+	case "monthly_spending", "target_balance":
+		return roundCents(math.Max(amount-carry, 0))
+	case "target_balance_by_date":
+		remaining := amount - carry
+		if remaining <= 0 {
+			return 0
+		}
+		months := 1
+		if due, err := time.Parse(dateLayout, category.TargetDate); err == nil {
+			months = (due.Year()-month.Year())*12 + int(due.Month()-month.Month()) + 1
+		}
+		if months < 1 {
+			months = 1
+		}
+		// Round up so the target is met by its date, ignoring float noise
+		return math.Ceil(remaining/float64(months)*100-1e-6) / 100
+	}
+	return 0
+}
+
+// mondaysIn counts the weeks starting in month.
+func mondaysIn(month time.Time) int {
+	first := periodStart(month, "weekly")
+	if first.Before(month) {
+		first = first.AddDate(0, 0, 7)
+	}
+	return (month.AddDate(0, 1, -1).Day()-first.Day())/7 + 1
+}
@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func TestCarryover(t *testing.T) {
+	tests := []struct {
+		name           string
+		cash, credit   string
+		available      float64
+		creditSpending float64
+		want           float64
+	}{
+		{"positive balance carries", "carry", "reset", 25.5, 0, 25.5},
+		{"zero", "carry", "reset", 0, 100, 0},
+		{"cash overspending carries by default", "carry", "reset", -40, 0, -40},
+		{"credit overspending resets by default", "carry", "reset", -40, 100, 0},
+		{"mixed overspending keeps only the cash part", "carry", "reset", -40, 15, -25},
+		{"both reset", "reset", "reset", -40, 15, 0},
+		{"both carry", "carry", "carry", -40, 15, -40},
+		{"credit carries, cash resets", "reset", "carry", -40, 15, -15},
+		{"rounds to cents", "carry", "reset", -10.005, 0, -10.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := rolloverConfig{CashOverspending: tt.cash, CreditOverspending: tt.credit}
+			if got := cfg.carryover(tt.available, tt.creditSpending); got != tt.want {
+				t.Errorf("carryover(%v, %v) = %v, want %v", tt.available, tt.creditSpending, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetAssignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		category categoryTarget
+		month    string
+		carry    float64
+		want     float64
+	}{
+		{"no target", categoryTarget{TargetType: "none", TargetAmount: 100}, "2026-10-01", 0, 0},
+		{"monthly contribution ignores carryover", categoryTarget{TargetType: "monthly_contribution", TargetAmount: 50}, "2026-10-01", 80, 50},
+		{"weekly contribution, four Mondays", categoryTarget{TargetType: "weekly_contribution", TargetAmount: 10}, "2026-10-01", 0, 40},
+		{"weekly contribution, five Mondays", categoryTarget{TargetType: "weekly_contribution", TargetAmount: 10}, "2026-08-01", 0, 50},
+		{"monthly spending tops up", categoryTarget{TargetType: "monthly_spending", TargetAmount: 300}, "2026-10-01", 120, 180},
+		{"monthly spending already covered", categoryTarget{TargetType: "monthly_spending", TargetAmount: 300}, "2026-10-01", 350, 0},
+		{"target balance after overspending", categoryTarget{TargetType: "target_balance", TargetAmount: 100}, "2026-10-01", -20, 120},
+		{"dated target spread over months left", categoryTarget{TargetType: "target_balance_by_date", TargetAmount: 1000, TargetDate: "2026-12-15"}, "2026-10-01", 100, 300},
+		{"dated target rounds up", categoryTarget{TargetType: "target_balance_by_date", TargetAmount: 100, TargetDate: "2026-12-31"}, "2026-10-01", 0, 33.34},
+		{"dated target due this month", categoryTarget{TargetType: "target_balance_by_date", TargetAmount: 500, TargetDate: "2026-10-31"}, "2026-10-01", 200, 300},
+		{"dated target past due asks for the rest", categoryTarget{TargetType: "target_balance_by_date", TargetAmount: 500, TargetDate: "2026-06-30"}, "2026-10-01", 200, 300},
+		{"dated target already met", categoryTarget{TargetType: "target_balance_by_date", TargetAmount: 500, TargetDate: "2027-06-30"}, "2026-10-01", 600, 0},
+		{"dated target without a date", categoryTarget{TargetType: "target_balance_by_date", TargetAmount: 500}, "2026-10-01", 0, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetAssignment(tt.category, mustDate(t, tt.month), tt.carry); got != tt.want {
+				t.Errorf("targetAssignment = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMondaysIn(t *testing.T) {
+	tests := []struct {
+		month string
+		want  int
+	}{
+		{"2026-10-01", 4}, // starts on a Thursday
+		{"2026-08-01", 5}, // Mondays on the 3rd and the 31st
+		{"2026-06-01", 5}, // starts on a Monday
+		{"2027-02-01", 4}, // February starting on a Monday
+		{"2026-02-01", 4}, // starts on a Sunday
+		{"2026-03-01", 5}, // Mondays on the 2nd and the 30th
+	}
+	for _, tt := range tests {
+		if got := mondaysIn(mustDate(t, tt.month)); got != tt.want {
+			t.Errorf("mondaysIn(%s) = %d, want %d", tt.month, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Public settings for the frontend, read at runtime so self-hosters can
+// change endpoints without rebuilding the image. Build-time VITE_* values
+// remain the fallback in the frontend.
+type frontendConfig struct {
+	SupabaseAnonKey  string
+	TurnstileSiteKey string
+	PosthogKey       string
+	PosthogHost      string
+}
+
+func loadFrontendConfig(src *configSource) frontendConfig {
+	return frontendConfig{
+		SupabaseAnonKey:  src.getEnv("SUPABASE_ANON_KEY", src.getEnv("PUBLIC_SUPABASE_ANON_KEY", src.getEnv("VITE_SUPABASE_ANON_KEY", ""))),
+		TurnstileSiteKey: src.getEnv("TURNSTILE_SITE_KEY", src.getEnv("VITE_TURNSTILE_SITE_KEY", "")),
+		PosthogKey:       src.getEnv("PUBLIC_POSTHOG_KEY", src.getEnv("VITE_PUBLIC_POSTHOG_KEY", "")),
+		PosthogHost:      src.getEnv("PUBLIC_POSTHOG_HOST", src.getEnv("VITE_PUBLIC_POSTHOG_HOST", "https://us.i.posthog.com")),
+	}
+}
+
+// publicConfig is everything the browser may see. Never add secrets here:
+// it is served to anonymous visitors.
+type publicConfig struct {
+	SupabaseURL      string         `json:"supabaseUrl,omitempty"`
+	SupabaseAnonKey  string         `json:"supabaseAnonKey,omitempty"`
+	TurnstileSiteKey string         `json:"turnstileSiteKey,omitempty"`
+	PosthogKey       string         `json:"posthogKey,omitempty"`
+	PosthogHost      string         `json:"posthogHost,omitempty"`
+	Version          string         `json:"version"`
+	Features         publicFeatures `json:"features"`
+}
+
+type publicFeatures struct {
+	AIChat    bool `json:"aiChat"`
+	Voice     bool `json:"voice"`
+	Shortcuts bool `json:"shortcuts"`
+}
+
+func (a *app) currentPublicConfig() publicConfig {
+	frontend := a.cfg.Frontend
+	return publicConfig{
+		SupabaseURL:      a.cfg.Supabase.URL,
+		SupabaseAnonKey:  frontend.SupabaseAnonKey,
+		TurnstileSiteKey: frontend.TurnstileSiteKey,
+		PosthogKey:       frontend.PosthogKey,
+		PosthogHost:      frontend.PosthogHost,
+		Version:          version,
+		// What is on for everyone; signed-in clients refine this from
+		// /api/features
+		Features: publicFeatures{
+			AIChat:    a.featureEnabled(featureAIChat, featureSubject{}),
+			Voice:     a.featureEnabled(featureVoice, featureSubject{}),
+			Shortcuts: a.featureEnabled(featureShortcuts, featureSubject{}),
+		},
+	}
+}
+
+// Public runtime configuration as JSON
+func (a *app) configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, a.currentPublicConfig())
+}
+
+// Public runtime configuration as a script, loaded by index.html before the
+// app bundle so the settings are available synchronously at startup
+func (a *app) configScriptHandler(w http.ResponseWriter, r *http.Request) {
+	payload, err := json.Marshal(a.currentPublicConfig())
+	if err != nil {
+		http.Error(w, "Failed to encode config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte("window.__YABT_CONFIG__ = "))
+	w.Write(payload)
+	w.Write([]byte(";\n"))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scheduled transactions are posted by a data job: every due occurrence
+// becomes a real transaction in its account, next_date moves on, and the
+// budget owner gets a notification listing what was posted.
+type scheduledConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	// Occurrences posted per schedule in one run. A schedule far in the
+	// past keeps catching up on later runs instead of all at once.
+	MaxCatchUp int
+}
+
+func loadScheduledConfig(src *configSource) scheduledConfig {
+	return scheduledConfig{
+		Enabled:    src.getEnvBool("SCHEDULED_TRANSACTIONS", true),
+		Interval:   src.getEnvDuration("SCHEDULED_TRANSACTIONS_INTERVAL", 15*time.Minute),
+		MaxCatchUp: src.getEnvInt("SCHEDULED_TRANSACTIONS_MAX_CATCH_UP", 400),
+	}
+}
+
+// Occurrences listed in a notification before the rest are summarised
+const scheduledNotificationItems = 5
+
+type postedOccurrence struct {
+	scheduled scheduledTransactionRecord
+	date      string
+}
+
+// runScheduledTransactions posts every occurrence due on or before today,
+// oldest first. Each occurrence is claimed by moving next_date on from
+// its date, so replicas running concurrently or a run interrupted halfway
+// never post the same occurrence twice.
+func runScheduledTransactions(ctx context.Context, store dataStore, today time.Time, cfg scheduledConfig) error {
+	due, err := store.listDueScheduledTransactions(ctx, today.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+
+	posted := make(map[string][]postedOccurrence)
+	var users []string
+	failed := 0
+	for _, scheduled := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		occurrences, err := postDueOccurrences(ctx, store, scheduled, today, cfg.MaxCatchUp)
+		if len(occurrences) > 0 {
+			if _, ok := posted[scheduled.UserID]; !ok {
+				users = append(users, scheduled.UserID)
+			}
+			posted[scheduled.UserID] = append(posted[scheduled.UserID], occurrences...)
+		}
+		if err != nil {
+			failed++
+			logger.Error("Failed to post scheduled transaction",
+				"scheduledTransactionId", scheduled.ID,
+				"budgetId", scheduled.BudgetID,
+				"error", err)
+		}
+	}
+
+	for _, userID := range users {
+		occurrences := posted[userID]
+		if err := store.createNotification(ctx, scheduledNotification(occurrences)); err != nil {
+			logger.Warn("Failed to notify about scheduled transactions", "userId", userID, "error", err)
+		}
+		logger.Info("Posted scheduled transactions", "userId", userID, "count", len(occurrences))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d due scheduled transactions failed to post", failed, len(due))
+	}
+	return nil
+}
+
+// postDueOccurrences posts scheduled from its next date through today,
+// stopping early if another runner claims an occurrence first or after
+// maxCatchUp occurrences.
+func postDueOccurrences(ctx context.Context, store dataStore, scheduled scheduledTransactionRecord, today time.Time, maxCatchUp int) ([]postedOccurrence, error) {
+	date, err := time.Parse(dateLayout, scheduled.NextDate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid next_date %q", scheduled.NextDate)
+	}
+	if scheduled.AnchorDay == 0 {
+		scheduled.AnchorDay = date.Day()
+	}
+
+	var posted []postedOccurrence
+	for n := 0; n < maxCatchUp && !date.After(today); n++ {
+		next, err := nextScheduledDate(date, scheduled.Frequency, scheduled.AnchorDay)
+		if err != nil {
+			return posted, err
+		}
+		ok, err := store.postScheduledTransaction(ctx, scheduled, date.Format(dateLayout), next.Format(dateLayout))
+		if ok {
+			scheduledTransactionsPostedTotal.inc()
+			posted = append(posted, postedOccurrence{scheduled: scheduled, date: date.Format(dateLayout)})
+		}
+		if err != nil || !ok {
+			return posted, err
+		}
+		date = next
+	}
+	return posted, nil
+}
+
+// nextScheduledDate returns the occurrence after date. Monthly and yearly
+// schedules fall on anchorDay, or the last day of months that are shorter.
+func nextScheduledDate(date time.Time, frequency string, anchorDay int) (time.Time, error) {
+	switch frequency {
+	case "daily":
+		return date.AddDate(0, 0, 1), nil
+	case "weekly":
+		return date.AddDate(0, 0, 7), nil
+	case "biweekly":
+		return date.AddDate(0, 0, 14), nil
+	case "monthly":
+		return addMonthsClamped(date, 1, anchorDay), nil
+	case "yearly":
+		return addMonthsClamped(date, 12, anchorDay), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown frequency %q", frequency)
+}
+
+// addMonthsClamped moves date on by months and onto day, clamped to the
+// length of the resulting month. time.AddDate would instead roll Jan 31
+// over into March.
+func addMonthsClamped(date time.Time, months, day int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func scheduledNotification(occurrences []postedOccurrence) notificationInput {
+	title := "Posted 1 scheduled transaction"
+	if len(occurrences) != 1 {
+		title = fmt.Sprintf("Posted %d scheduled transactions", len(occurrences))
+	}
+
+	items := make([]string, 0, scheduledNotificationItems+1)
+	for i, occurrence := range occurrences {
+		if i == scheduledNotificationItems {
+			items = append(items, fmt.Sprintf("and %d more", len(occurrences)-i))
+			break
+		}
+		name := occurrence.scheduled.PayeeName
+		if name == "" {
+			name = occurrence.scheduled.AccountName
+		}
+		items = append(items, fmt.Sprintf("%s %.2f on %s", name, occurrence.scheduled.Amount, occurrence.date))
+	}
+
+	return notificationInput{
+		UserID:  occurrences[0].scheduled.UserID,
+		Type:    "scheduled_transaction",
+		Title:   title,
+		Message: strings.Join(items, ", "),
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustDate(t *testing.T, value string) time.Time {
+	t.Helper()
+	date, err := time.Parse(dateLayout, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return date
+}
+
+func TestAddMonthsClamped(t *testing.T) {
+	tests := []struct {
+		date   string
+		months int
+		day    int
+		want   string
+	}{
+		{"2026-01-31", 1, 31, "2026-02-28"},
+		{"2026-02-28", 1, 31, "2026-03-31"},
+		{"2026-03-31", 1, 31, "2026-04-30"},
+		{"2028-01-31", 1, 31, "2028-02-29"},
+		{"2028-01-30", 1, 30, "2028-02-29"},
+		{"2026-01-15", 1, 15, "2026-02-15"},
+		{"2026-12-31", 1, 31, "2027-01-31"},
+		{"2028-02-29", 12, 29, "2029-02-28"},
+		{"2029-02-28", 12, 29, "2030-02-28"},
+		{"2031-02-28", 12, 29, "2032-02-29"},
+	}
+	for _, tt := range tests {
+		got := addMonthsClamped(mustDate(t, tt.date), tt.months, tt.day).Format(dateLayout)
+		if got != tt.want {
+			t.Errorf("addMonthsClamped(%s, %d, %d) = %s, want %s", tt.date, tt.months, tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestNextScheduledDate(t *testing.T) {
+	tests := []struct {
+		date      string
+		frequency string
+		anchorDay int
+		want      string
+		wantErr   bool
+	}{
+		{"2026-02-27", "daily", 27, "2026-02-28", false},
+		{"2026-02-25", "weekly", 25, "2026-03-04", false},
+		{"2026-02-25", "biweekly", 25, "2026-03-11", false},
+		{"2026-02-28", "monthly", 31, "2026-03-31", false},
+		{"2026-02-28", "monthly", 28, "2026-03-28", false},
+		{"2028-02-29", "yearly", 29, "2029-02-28", false},
+		{"2026-02-28", "fortnightly", 28, "", true},
+	}
+	for _, tt := range tests {
+		got, err := nextScheduledDate(mustDate(t, tt.date), tt.frequency, tt.anchorDay)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("nextScheduledDate(%s, %s, %d) error = %v, want error %v", tt.date, tt.frequency, tt.anchorDay, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got.Format(dateLayout) != tt.want {
+			t.Errorf("nextScheduledDate(%s, %s, %d) = %s, want %s", tt.date, tt.frequency, tt.anchorDay, got.Format(dateLayout), tt.want)
+		}
+	}
+}
+
+// scheduledStore records posted occurrences and claims until claimLimit.
+type scheduledStore struct {
+	dataStore
+	claimLimit int
+	posted     [][2]string // date, next
+}
+
+func (s *scheduledStore) postScheduledTransaction(ctx context.Context, scheduled scheduledTransactionRecord, date, next string) (bool, error) {
+	if s.claimLimit > 0 && len(s.posted) == s.claimLimit {
+		return false, nil
+	}
+	s.posted = append(s.posted, [2]string{date, next})
+	return true, nil
+}
+
+func TestPostDueOccurrences(t *testing.T) {
+	tests := []struct {
+		name       string
+		scheduled  scheduledTransactionRecord
+		today      string
+		maxCatchUp int
+		claimLimit int
+		want       [][2]string
+	}{
+		{
+			name:       "monthly on the 31st catches up through February",
+			scheduled:  scheduledTransactionRecord{Frequency: "monthly", NextDate: "2026-01-31"},
+			today:      "2026-04-30",
+			maxCatchUp: 400,
+			want: [][2]string{
+				{"2026-01-31", "2026-02-28"},
+				{"2026-02-28", "2026-03-31"},
+				{"2026-03-31", "2026-04-30"},
+				{"2026-04-30", "2026-05-31"},
+			},
+		},
+		{
+			name:       "stored anchor day wins over a clamped next date",
+			scheduled:  scheduledTransactionRecord{Frequency: "monthly", NextDate: "2026-02-28", AnchorDay: 31},
+			today:      "2026-03-31",
+			maxCatchUp: 400,
+			want: [][2]string{
+				{"2026-02-28", "2026-03-31"},
+				{"2026-03-31", "2026-04-30"},
+			},
+		},
+		{
+			name:       "yearly on February 29",
+			scheduled:  scheduledTransactionRecord{Frequency: "yearly", NextDate: "2028-02-29"},
+			today:      "2032-03-01",
+			maxCatchUp: 400,
+			want: [][2]string{
+				{"2028-02-29", "2029-02-28"},
+				{"2029-02-28", "2030-02-28"},
+				{"2030-02-28", "2031-02-28"},
+				{"2031-02-28", "2032-02-29"},
+				{"2032-02-29", "2033-02-28"},
+			},
+		},
+		{
+			name:       "stops at the catch-up limit",
+			scheduled:  scheduledTransactionRecord{Frequency: "weekly", NextDate: "2026-01-01"},
+			today:      "2026-12-31",
+			maxCatchUp: 2,
+			want: [][2]string{
+				{"2026-01-01", "2026-01-08"},
+				{"2026-01-08", "2026-01-15"},
+			},
+		},
+		{
+			name:       "stops when another runner claims first",
+			scheduled:  scheduledTransactionRecord{Frequency: "daily", NextDate: "2026-03-01"},
+			today:      "2026-03-05",
+			maxCatchUp: 400,
+			claimLimit: 1,
+			want: [][2]string{
+				{"2026-03-01", "2026-03-02"},
+			},
+		},
+		{
+			name:       "nothing due yet",
+			scheduled:  scheduledTransactionRecord{Frequency: "monthly", NextDate: "2026-05-01"},
+			today:      "2026-04-30",
+			maxCatchUp: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &scheduledStore{claimLimit: tt.claimLimit}
+			posted, err := postDueOccurrences(context.Background(), fake, tt.scheduled, mustDate(t, tt.today), tt.maxCatchUp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fake.posted, tt.want) {
+				t.Errorf("posted %v, want %v", fake.posted, tt.want)
+			}
+			if len(posted) != len(tt.want) {
+				t.Errorf("returned %d occurrences, want %d", len(posted), len(tt.want))
+			}
+		})
+	}
+}
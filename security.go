@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Browser security headers. The Content-Security-Policy is derived from the
+// configured Supabase URL and PostHog host; CSP_POLICY replaces it outright.
+type securityConfig struct {
+	Headers        bool
+	CSPPolicy      string
+	CSPReportOnly  bool
+	CSPConnectSrc  string // additional origins, space-separated
+	FrameAncestors string
+	ReferrerPolicy string
+	// Microphone is needed for voice input in Quick Add
+	PermissionsPolicy string
+
+	CSPReportRateLimit int
+}
+
+func loadSecurityConfig(src *configSource) securityConfig {
+	return securityConfig{
+		Headers:            src.getEnvBool("SECURITY_HEADERS", true),
+		CSPPolicy:          src.getEnv("CSP_POLICY", ""),
+		CSPReportOnly:      src.getEnvBool("CSP_REPORT_ONLY", false),
+		CSPConnectSrc:      src.getEnv("CSP_CONNECT_SRC", ""),
+		FrameAncestors:     src.getEnv("FRAME_ANCESTORS", "'none'"),
+		ReferrerPolicy:     src.getEnv("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		PermissionsPolicy:  src.getEnv("PERMISSIONS_POLICY", "microphone=(self), camera=(), geolocation=(), payment=(), usb=()"),
+		CSPReportRateLimit: src.getEnvInt("CSP_REPORT_RATE_LIMIT", 60),
+	}
+}
+
+const (
+	cspReportPath     = "/api/csp-report"
+	cspReportMaxBytes = 64 << 10
+	turnstileOrigin   = "https://challenges.cloudflare.com"
+)
+
+// securityHeadersMiddleware sets the security headers on every response.
+// The values are computed once at startup.
+func securityHeadersMiddleware(cfg *Config, next http.Handler) http.Handler {
+	sec := cfg.Security
+	if !sec.Headers {
+		return next
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if sec.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	csp := buildCSP(sec, cfg.Supabase.URL, cfg.Frontend.PosthogHost)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set(cspHeader, csp)
+		h.Set("Reporting-Endpoints", `csp="`+cspReportPath+`"`)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", sec.ReferrerPolicy)
+		h.Set("Permissions-Policy", sec.PermissionsPolicy)
+		if sec.FrameAncestors == "'none'" {
+			// For browsers that predate frame-ancestors
+			h.Set("X-Frame-Options", "DENY")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// buildCSP assembles the policy for the SPA: Supabase for data, auth and
+// realtime, PostHog for analytics, and Cloudflare Turnstile on the auth pages.
+func buildCSP(sec securityConfig, supabaseURL, posthogHost string) string {
+	if sec.CSPPolicy != "" {
+		return sec.CSPPolicy
+	}
+
+	scriptSrc := []string{"'self'", turnstileOrigin}
+	connectSrc := []string{"'self'"}
+
+	if origin := originOf(supabaseURL); origin != "" {
+		connectSrc = append(connectSrc, origin, strings.Replace(origin, "http", "ws", 1))
+	}
+	if origin := originOf(posthogHost); origin != "" {
+		connectSrc = append(connectSrc, origin)
+		// PostHog serves its recorder and toolbar scripts from a sibling
+		// assets host, e.g. us.i.posthog.com -> us-assets.i.posthog.com
+		if u, _ := url.Parse(origin); u != nil && strings.HasSuffix(u.Host, ".i.posthog.com") {
+			region := strings.TrimSuffix(u.Host, ".i.posthog.com")
+			assets := u.Scheme + "://" + region + "-assets.i.posthog.com"
+			scriptSrc = append(scriptSrc, assets)
+			connectSrc = append(connectSrc, assets)
+		}
+	}
+	connectSrc = append(connectSrc, strings.Fields(sec.CSPConnectSrc)...)
+
+	directives := []string{
+		"default-src 'self'",
+		"script-src " + strings.Join(scriptSrc, " "),
+		// React and the chart library set inline style attributes
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' data: blob:",
+		"font-src 'self' data:",
+		"connect-src " + strings.Join(connectSrc, " "),
+		"media-src 'self' blob:",
+		"worker-src 'self' blob:",
+		"frame-src " + turnstileOrigin,
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + sec.FrameAncestors,
+		"report-uri " + cspReportPath,
+		"report-to csp",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// originOf returns scheme://host of rawURL, or "" when it is not absolute.
+func originOf(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// cspViolation holds the fields common to both report formats.
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"`
+}
+
+// reportingAPIViolation is the Reporting API body, which uses camelCase.
+type reportingAPIViolation struct {
+	DocumentURL        string `json:"documentURL"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	Disposition        string `json:"disposition"`
+}
+
+// cspReportHandler receives violation reports from browsers, both the
+// legacy report-uri format and the Reporting API, and logs each one.
+func (a *app) cspReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ok, _ := a.cspReportLimiter.allow("ip:"+clientIP(r), 1); !ok {
+		// Browsers do not retry reports, so there is nothing to tell them
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cspReportMaxBytes)
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid report", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var violations []cspViolation
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var reports []struct {
+			Type string                `json:"type"`
+			Body reportingAPIViolation `json:"body"`
+		}
+		if err := json.Unmarshal(raw, &reports); err != nil {
+			http.Error(w, "Invalid report", http.StatusBadRequest)
+			return
+		}
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, cspViolation{
+				DocumentURI:        report.Body.DocumentURL,
+				BlockedURI:         report.Body.BlockedURL,
+				EffectiveDirective: report.Body.EffectiveDirective,
+				SourceFile:         report.Body.SourceFile,
+				LineNumber:         report.Body.LineNumber,
+				Disposition:        report.Body.Disposition,
+			})
+		}
+	} else {
+		var report struct {
+			Report cspViolation `json:"csp-report"`
+		}
+		if err := json.Unmarshal(raw, &report); err != nil {
+			http.Error(w, "Invalid report", http.StatusBadRequest)
+			return
+		}
+		violations = append(violations, report.Report)
+	}
+
+	reqLog := loggerFrom(r.Context()).With("source", "csp")
+	for _, v := range violations {
+		directive := v.EffectiveDirective
+		if directive == "" {
+			directive = v.ViolatedDirective
+		}
+		reqLog.Warn("CSP violation",
+			"cspDirective", truncateString(directive, 200),
+			"cspBlockedUri", stripQuery(v.BlockedURI),
+			"cspDocumentUri", stripQuery(v.DocumentURI),
+			"cspSourceFile", stripQuery(v.SourceFile),
+			"cspLine", v.LineNumber,
+			"cspDisposition", v.Disposition,
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stripQuery drops the query and fragment, which may carry tokens, and
+// bounds the length of a URI taken from a report.
+func stripQuery(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+	return truncateString(uri, 500)
+}
@@ -8,6 +8,14 @@ import { useState } from 'react'
 import { Loader2, Wand2, X, Mic, MicOff } from 'lucide-react'
 import { useBudget } from '@/contexts/BudgetContext'
 import { useData } from '@/contexts/DataContext'
+import { useFeatures } from '@/lib/runtimeConfig'
+import { supabase } from '@/lib/supabase'
+
+// Session token for server features that are rolled out per user
+const authHeaders = async (): Promise<Record<string, string>> => {
+    const { data: { session } } = await supabase.auth.getSession()
+    return session?.access_token ? { Authorization: `Bearer ${session.access_token}` } : {}
+}
 
 interface ParsedTransaction {
     amount: number | null
@@ -84,6 +92,7 @@ const normalizeParsedTransaction = (raw: any): ParsedTransaction => {
 export default function NLPTransactionInput({ onClose, onSuccess }: NLPTransactionInputProps) {
     const { currentBudget } = useBudget()
     const { dataService, isInitialized } = useData()
+    const features = useFeatures()
     const [text, setText] = useState('')
     const [loading, setLoading] = useState(false)
     const [isListening, setIsListening] = useState(false)
@@ -106,7 +115,8 @@ export default function NLPTransactionInput({ onClose, onSuccess }: NLPTransacti
                 {
                     method: 'POST',
                     headers: {
-                        'Content-Type': 'application/json'
+                        'Content-Type': 'application/json',
+                        ...(await authHeaders()),
                     },
                     body: JSON.stringify({
                         model: 'gpt-oss:20b-cloud',
@@ -367,6 +377,7 @@ Rules:
 
             const response = await fetch('/api/ai/transcribe', {
                 method: 'POST',
+                headers: await authHeaders(),
                 body: formData,
             })
 
@@ -430,16 +441,18 @@ Rules:
                             <label htmlFor="nlp-transaction" className="block text-sm font-medium text-slate-700 dark:text-slate-300">
                                 Describe your transaction
                             </label>
-                            <button
-                                onClick={toggleListening}
-                                className={`p-2 rounded-full transition-colors ${isListening
-                                    ? 'bg-red-100 text-red-600 animate-pulse'
-                                    : 'bg-slate-100 text-slate-600 hover:bg-slate-200 dark:bg-slate-700 dark:text-slate-300'
-                                    }`}
-                                title={isListening ? 'Stop listening' : 'Start listening'}
-                            >
-                                {isListening ? <MicOff className="w-4 h-4" /> : <Mic className="w-4 h-4" />}
-                            </button>
+                            {features.voice && (
+                                <button
+                                    onClick={toggleListening}
+                                    className={`p-2 rounded-full transition-colors ${isListening
+                                        ? 'bg-red-100 text-red-600 animate-pulse'
+                                        : 'bg-slate-100 text-slate-600 hover:bg-slate-200 dark:bg-slate-700 dark:text-slate-300'
+                                        }`}
+                                    title={isListening ? 'Stop listening' : 'Start listening'}
+                                >
+                                    {isListening ? <MicOff className="w-4 h-4" /> : <Mic className="w-4 h-4" />}
+                                </button>
+                            )}
                         </div>
                         <textarea
                             id="nlp-transaction"
@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react'
-import { Bell, X, Check, AlertCircle, Info } from 'lucide-react'
+import { Bell, X, Check, AlertCircle, Info, Repeat } from 'lucide-react'
 import { supabase } from '@/lib/supabase'
 
 interface Notification {
@@ -70,6 +70,8 @@ export default function NotificationBell() {
                 return <AlertCircle className="w-4 h-4 text-red-500" />
             case 'success':
                 return <Check className="w-4 h-4 text-emerald-500" />
+            case 'scheduled_transaction':
+                return <Repeat className="w-4 h-4 text-violet-500" />
             default:
                 return <Info className="w-4 h-4 text-blue-500" />
         }
@@ -7,6 +7,8 @@
 import { createContext, useContext, useEffect, useState, ReactNode } from 'react'
 import { useAuth } from './AuthContext'
 import { useData } from './DataContext'
+import { supabase } from '@/lib/supabase'
+import { refreshFeatures } from '@/lib/runtimeConfig'
 
 interface Budget {
     id: string
@@ -65,6 +67,16 @@ export function BudgetProvider({ children }: { children: ReactNode }) {
         }
     }, [user, isInitialized, dataService])
 
+    // Per-user and per-budget feature switches from the server
+    useEffect(() => {
+        if (!user) return
+        supabase.auth.getSession().then(({ data: { session } }) => {
+            if (session?.access_token) {
+                refreshFeatures(session.access_token, currentBudget?.id)
+            }
+        })
+    }, [user, currentBudget?.id])
+
     const switchBudget = (budgetId: string) => {
         const budget = allBudgets.find(b => b.id === budgetId)
         if (budget) {
@@ -69,6 +69,8 @@ export interface MonthlyBudget {
     budgeted: number
     activity: number
     available: number
+    // Brought forward from the previous month by the server's month rollover
+    carryover?: number
 }
 
 export interface Tag {
@@ -2,30 +2,72 @@ import { supabase } from './supabase'
 
 type LogLevel = 'info' | 'warn' | 'error' | 'debug'
 
+interface LogEntry {
+    level: LogLevel
+    message: string
+    timestamp: string
+    meta: any
+}
+
+// Entries are sent in batches; the server accepts up to 50 per request
+const MAX_BATCH = 50
+const FLUSH_INTERVAL_MS = 2000
+
 class Logger {
-    private async ship(level: LogLevel, message: string, meta: any = {}) {
+    private queue: LogEntry[] = []
+    private timer: ReturnType<typeof setTimeout> | null = null
+
+    constructor() {
+        if (typeof window !== 'undefined') {
+            window.addEventListener('pagehide', () => this.flush())
+        }
+    }
+
+    private ship(level: LogLevel, message: string, meta: any = {}) {
+        // Log to console in development
+        if (import.meta.env.DEV) {
+            console.log(`[${level.toUpperCase()}] ${message}`, meta)
+        }
+
+        this.queue.push({ level, message, timestamp: new Date().toISOString(), meta })
+
+        if (this.queue.length >= MAX_BATCH || level === 'error') {
+            this.flush()
+        } else if (!this.timer) {
+            this.timer = setTimeout(() => this.flush(), FLUSH_INTERVAL_MS)
+        }
+    }
+
+    private async flush() {
+        if (this.timer) {
+            clearTimeout(this.timer)
+            this.timer = null
+        }
+        if (this.queue.length === 0) return
+
+        const batch = this.queue.splice(0, MAX_BATCH)
+
         try {
+            // The server attributes logs to the verified session, not to a user field
             const { data: { session } } = await supabase.auth.getSession()
-            const user = session?.user?.email || null
-
-            // Log to console in development
-            if (import.meta.env.DEV) {
-                console.log(`[${level.toUpperCase()}] ${message}`, meta)
+            const headers: Record<string, string> = { 'Content-Type': 'application/json' }
+            if (session?.access_token) {
+                headers.Authorization = `Bearer ${session.access_token}`
             }
 
             await fetch('/api/log', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({
-                    level,
-                    message,
-                    user,
-                    meta
-                })
+                headers,
+                body: JSON.stringify(batch),
+                keepalive: true
             })
         } catch (e) {
             console.error('Failed to ship log', e)
         }
+
+        if (this.queue.length > 0) {
+            this.flush()
+        }
     }
 
     info(message: string, meta?: any) {
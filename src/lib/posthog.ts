@@ -1,7 +1,8 @@
 import posthog from 'posthog-js'
+import { runtimeConfig } from './runtimeConfig'
 
-const POSTHOG_KEY = import.meta.env.VITE_PUBLIC_POSTHOG_KEY
-const POSTHOG_HOST = import.meta.env.VITE_PUBLIC_POSTHOG_HOST || 'https://us.i.posthog.com'
+const POSTHOG_KEY = runtimeConfig.posthogKey
+const POSTHOG_HOST = runtimeConfig.posthogHost
 
 export function initPostHog() {
     if (POSTHOG_KEY) {
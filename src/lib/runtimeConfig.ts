@@ -0,0 +1,98 @@
+// Public settings served by the Go server at /config.js, so a deployment can
+// change them without rebuilding. Build-time VITE_* values are the fallback,
+// e.g. under the Vite dev server.
+
+import { useSyncExternalStore } from 'react'
+
+export interface RuntimeFeatures {
+    aiChat: boolean
+    voice: boolean
+    shortcuts: boolean
+}
+
+interface InjectedConfig {
+    supabaseUrl?: string
+    supabaseAnonKey?: string
+    turnstileSiteKey?: string
+    posthogKey?: string
+    posthogHost?: string
+    version?: string
+    features?: Partial<RuntimeFeatures>
+}
+
+declare global {
+    interface Window {
+        __YABT_CONFIG__?: InjectedConfig
+    }
+}
+
+const injected: InjectedConfig = window.__YABT_CONFIG__ ?? {}
+
+const envString = (value: unknown) => (typeof value === 'string' ? value : '')
+
+export const runtimeConfig = {
+    supabaseUrl: injected.supabaseUrl || envString(import.meta.env.VITE_SUPABASE_URL),
+    supabaseAnonKey: injected.supabaseAnonKey || envString(import.meta.env.VITE_SUPABASE_ANON_KEY),
+    turnstileSiteKey: injected.turnstileSiteKey || envString(import.meta.env.VITE_TURNSTILE_SITE_KEY),
+    posthogKey: injected.posthogKey || envString(import.meta.env.VITE_PUBLIC_POSTHOG_KEY),
+    posthogHost: injected.posthogHost || envString(import.meta.env.VITE_PUBLIC_POSTHOG_HOST) || 'https://us.i.posthog.com',
+    version: injected.version || 'dev',
+}
+
+// Feature switches are an immutable snapshot that refreshFeatures replaces,
+// so components reading them through useFeatures re-render. Without server
+// config, assume everything is available and let the API report otherwise.
+let features: Readonly<RuntimeFeatures> = Object.freeze({
+    aiChat: injected.features?.aiChat ?? true,
+    voice: injected.features?.voice ?? true,
+    shortcuts: injected.features?.shortcuts ?? true,
+})
+const featureListeners = new Set<() => void>()
+
+export function getFeatures(): Readonly<RuntimeFeatures> {
+    return features
+}
+
+export function subscribeFeatures(listener: () => void) {
+    featureListeners.add(listener)
+    return () => {
+        featureListeners.delete(listener)
+    }
+}
+
+export function useFeatures(): Readonly<RuntimeFeatures> {
+    return useSyncExternalStore(subscribeFeatures, getFeatures)
+}
+
+interface FeatureState {
+    enabled: boolean
+    reason?: string
+}
+
+// Refines the features for the signed-in user and, when given, their
+// current budget. Failures keep the values from /config.js.
+export async function refreshFeatures(accessToken: string, budgetId?: string) {
+    const query = budgetId ? `?budgetId=${encodeURIComponent(budgetId)}` : ''
+    try {
+        const response = await fetch(`/api/features${query}`, {
+            headers: { Authorization: `Bearer ${accessToken}` },
+        })
+        if (!response.ok) return
+        const data = await response.json() as { features?: Record<string, FeatureState> }
+        const next: RuntimeFeatures = { ...features }
+        let changed = false
+        for (const [name, state] of Object.entries(data.features ?? {})) {
+            const key = name as keyof RuntimeFeatures
+            if (key in next && next[key] !== state.enabled) {
+                next[key] = state.enabled
+                changed = true
+            }
+        }
+        if (changed) {
+            features = Object.freeze(next)
+            featureListeners.forEach(listener => listener())
+        }
+    } catch {
+        // Keep the defaults
+    }
+}
@@ -1,7 +1,8 @@
 import { createClient } from '@supabase/supabase-js'
+import { runtimeConfig } from './runtimeConfig'
 
-const supabaseUrl = import.meta.env.VITE_SUPABASE_URL
-const supabaseAnonKey = import.meta.env.VITE_SUPABASE_ANON_KEY
+const supabaseUrl = runtimeConfig.supabaseUrl
+const supabaseAnonKey = runtimeConfig.supabaseAnonKey
 
 if (!supabaseUrl || !supabaseAnonKey) {
     throw new Error('Missing Supabase environment variables')
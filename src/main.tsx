@@ -2,10 +2,11 @@ import { StrictMode } from 'react'
 import { createRoot } from 'react-dom/client'
 import { PostHogProvider } from 'posthog-js/react'
 import App from './App'
+import { runtimeConfig } from './lib/runtimeConfig'
 import './index.css'
 
 const options = {
-    api_host: import.meta.env.VITE_PUBLIC_POSTHOG_HOST,
+    api_host: runtimeConfig.posthogHost,
     person_profiles: 'identified_only' as const,
     capture_pageview: false, // We'll manually capture page views for SPA
     capture_pageleave: true,
@@ -15,7 +16,7 @@ const options = {
 createRoot(document.getElementById('root')!).render(
     <StrictMode>
         <PostHogProvider
-            apiKey={import.meta.env.VITE_PUBLIC_POSTHOG_KEY}
+            apiKey={runtimeConfig.posthogKey}
             options={options}
         >
             <App />
@@ -192,8 +192,12 @@ export default function Budget() {
         return monthlyBudgets.find(b => b.category_id === categoryId)?.activity || 0
     }
 
+    const getCategoryCarryover = (categoryId: string) => {
+        return monthlyBudgets.find(b => b.category_id === categoryId)?.carryover || 0
+    }
+
     const getCategoryAvailable = (categoryId: string) => {
-        return getCategoryBudgeted(categoryId) + getCategoryActivity(categoryId)
+        return getCategoryCarryover(categoryId) + getCategoryBudgeted(categoryId) + getCategoryActivity(categoryId)
     }
 
     const toggleGroup = (groupId: string) => {
@@ -240,7 +244,7 @@ export default function Budget() {
                 month: monthStr,
                 budgeted: newBudgeted,
                 activity: getCategoryActivity(categoryId),
-                available: newBudgeted + getCategoryActivity(categoryId)
+                available: getCategoryCarryover(categoryId) + newBudgeted + getCategoryActivity(categoryId)
             })
 
             // Update local state
@@ -3,9 +3,10 @@ import { Link, useNavigate } from 'react-router-dom'
 import { Loader2, RefreshCw } from 'lucide-react'
 import { useAuth } from '@/contexts/AuthContext'
 import logo from '@/assets/logo.png'
+import { runtimeConfig } from '@/lib/runtimeConfig'
 
-// Get Turnstile site key from environment
-const TURNSTILE_SITE_KEY = String(import.meta.env.VITE_TURNSTILE_SITE_KEY || '')
+// Turnstile site key from the server's runtime config (or the build)
+const TURNSTILE_SITE_KEY = runtimeConfig.turnstileSiteKey
 
 // Turnstile type declarations
 declare global {
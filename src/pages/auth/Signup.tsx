@@ -3,16 +3,11 @@ import { Link, useNavigate } from 'react-router-dom'
 import { Loader2, Shield } from 'lucide-react'
 import { useAuth } from '@/contexts/AuthContext'
 import logo from '@/assets/logo.png'
+import { runtimeConfig } from '@/lib/runtimeConfig'
 
-// Cloudflare Turnstile Site Key (public - safe to expose)
-// Ensure it's a string - Vite can sometimes pass objects
-const rawSiteKey = import.meta.env.VITE_TURNSTILE_SITE_KEY
-const TURNSTILE_SITE_KEY = typeof rawSiteKey === 'string' ? rawSiteKey : ''
-
-// Debug log for troubleshooting
-if (rawSiteKey && typeof rawSiteKey !== 'string') {
-    console.error('[YABT] VITE_TURNSTILE_SITE_KEY is not a string:', typeof rawSiteKey, rawSiteKey)
-}
+// Cloudflare Turnstile Site Key (public - safe to expose), from the
+// server's runtime config or the build
+const TURNSTILE_SITE_KEY = runtimeConfig.turnstileSiteKey
 
 declare global {
     interface Window {
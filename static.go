@@ -0,0 +1,352 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type staticConfig struct {
+	DistPath string // overrides an embedded frontend
+	// Cache lifetime for dist files without a content hash in their name
+	// (favicon, manifest, robots.txt); hashed assets are cached for a year
+	CacheMaxAge time.Duration
+}
+
+func loadStaticConfig(src *configSource) staticConfig {
+	return staticConfig{
+		DistPath:    src.getEnv("DIST_PATH", ""),
+		CacheMaxAge: src.getEnvDuration("STATIC_CACHE_MAX_AGE", time.Hour),
+	}
+}
+
+const (
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Smaller responses are not worth compressing on the fly
+	minGzipSize = 1024
+)
+
+// Vite emits bundled assets as assets/name-<hash>.ext
+var hashedAssetRegex = regexp.MustCompile(`^/assets/.+-[A-Za-z0-9_-]{8}\.\w+$`)
+
+var fileExtRegex = regexp.MustCompile(`\.\w+$`)
+
+// The frontend compiled into the binary, set by embed.go in builds with
+// -tags embed
+var embeddedDist fs.FS
+
+// frontendFS returns the built SPA and where it comes from: DIST_PATH when
+// set, so development builds can be served from disk, then the embedded
+// copy, then ./dist.
+func frontendFS(distPath string) (fs.FS, string) {
+	if distPath != "" {
+		return os.DirFS(distPath), distPath
+	}
+	if embeddedDist != nil {
+		return embeddedDist, "embedded"
+	}
+	return os.DirFS("./dist"), "./dist"
+}
+
+// staticFile describes one servable file in dist.
+type staticFile struct {
+	name         string // within the frontend fs.FS
+	size         int64
+	modTime      time.Time
+	etag         string
+	contentType  string
+	cacheControl string
+	compressible bool
+	// Precompressed siblings by encoding ("br", "gzip"), built by the
+	// frontend tooling next to the original
+	variants map[string]*staticFile
+}
+
+// staticIndex is the set of dist files, built once at startup so requests
+// never touch the filesystem to find out what exists. Rebuilding the
+// frontend requires a restart.
+type staticIndex struct {
+	files map[string]*staticFile // by URL path
+}
+
+var precompressedExts = map[string]string{".br": "br", ".gz": "gzip"}
+
+func newStaticIndex(fsys fs.FS, cacheMaxAge time.Duration) (*staticIndex, error) {
+	index := &staticIndex{files: map[string]*staticFile{}}
+	compressed := map[string]map[string]*staticFile{}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		urlPath := "/" + name
+
+		file, err := indexStaticFile(fsys, name, urlPath, cacheMaxAge)
+		if err != nil {
+			return err
+		}
+
+		if encoding, ok := precompressedExts[path.Ext(urlPath)]; ok {
+			original := strings.TrimSuffix(urlPath, path.Ext(urlPath))
+			if compressed[original] == nil {
+				compressed[original] = map[string]*staticFile{}
+			}
+			compressed[original][encoding] = file
+		}
+		index.files[urlPath] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for original, variants := range compressed {
+		file, ok := index.files[original]
+		if !ok {
+			continue
+		}
+		for encoding, variant := range variants {
+			// Served in place of the original, so it takes its type and
+			// caching, with an ETag of its own
+			variant.contentType = file.contentType
+			variant.cacheControl = file.cacheControl
+			variant.etag = strings.TrimSuffix(file.etag, `"`) + "-" + encoding + `"`
+		}
+		file.variants = variants
+	}
+	return index, nil
+}
+
+func indexStaticFile(fsys fs.FS, name, urlPath string, cacheMaxAge time.Duration) (*staticFile, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(f, sniff)
+	hash.Write(sniff[:n])
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(urlPath))
+	if contentType == "" {
+		contentType = http.DetectContentType(sniff[:n])
+	}
+
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(cacheMaxAge.Seconds()))
+	switch {
+	case path.Base(urlPath) == "index.html":
+		// Always revalidate, so a deploy is picked up on the next load
+		cacheControl = "no-cache"
+	case hashedAssetRegex.MatchString(urlPath):
+		cacheControl = immutableCacheControl
+	}
+
+	return &staticFile{
+		name:         name,
+		size:         info.Size(),
+		modTime:      info.ModTime(),
+		etag:         `"` + hex.EncodeToString(hash.Sum(nil)[:8]) + `"`,
+		contentType:  contentType,
+		cacheControl: cacheControl,
+		compressible: isCompressible(contentType),
+	}, nil
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml",
+		"application/wasm", "application/manifest+json", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// spaHandler serves the built frontend, falling back to index.html for
+// client-side routes.
+func spaHandler(fsys fs.FS, cfg staticConfig) http.Handler {
+	index, err := newStaticIndex(fsys, cfg.CacheMaxAge)
+	if err != nil {
+		logger.Warn("Static files unavailable", "error", err)
+		index = &staticIndex{files: map[string]*staticFile{}}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		urlPath := path.Clean("/" + r.URL.Path)
+		if urlPath == "/" {
+			urlPath = "/index.html"
+		}
+
+		if file, ok := index.files[urlPath]; ok {
+			serveStaticFile(w, r, fsys, file)
+			return
+		}
+
+		// If it's a file request (has extension) and doesn't exist, return 404
+		if fileExtRegex.MatchString(urlPath) {
+			http.NotFound(w, r)
+			return
+		}
+
+		// SPA fallback - serve index.html
+		if file, ok := index.files["/index.html"]; ok {
+			serveStaticFile(w, r, fsys, file)
+			return
+		}
+
+		http.Error(w, "Application not built. Run npm run build first.", http.StatusNotFound)
+	})
+}
+
+func serveStaticFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, file *staticFile) {
+	h := w.Header()
+	h.Set("Cache-Control", file.cacheControl)
+	h.Set("Content-Type", file.contentType)
+
+	if len(file.variants) > 0 || file.compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	// Prefer a precompressed variant, then gzip on the fly. Range requests
+	// get the identity encoding so offsets stay meaningful.
+	if r.Header.Get("Range") == "" {
+		for _, encoding := range []string{"br", "gzip"} {
+			variant, ok := file.variants[encoding]
+			if !ok || !acceptsEncoding(r, encoding) {
+				continue
+			}
+			h.Set("Content-Encoding", encoding)
+			serveStaticContent(w, r, fsys, variant)
+			return
+		}
+
+		if file.compressible && file.size >= minGzipSize && acceptsEncoding(r, "gzip") {
+			serveGzipped(w, r, fsys, file)
+			return
+		}
+	}
+
+	serveStaticContent(w, r, fsys, file)
+}
+
+// serveStaticContent serves file as is; http.ServeContent answers
+// conditional and range requests from the ETag and modification time.
+func serveStaticContent(w http.ResponseWriter, r *http.Request, fsys fs.FS, file *staticFile) {
+	f, err := fsys.Open(file.name)
+	if err != nil {
+		http.Error(w, "File unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Files from os.DirFS and embed.FS can both seek
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "File unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", file.etag)
+	http.ServeContent(w, r, "", file.modTime, content)
+}
+
+var gzipWriters = sync.Pool{New: func() any {
+	gz, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+	return gz
+}}
+
+func serveGzipped(w http.ResponseWriter, r *http.Request, fsys fs.FS, file *staticFile) {
+	etag := strings.TrimSuffix(file.etag, `"`) + `-gzip"`
+	h := w.Header()
+	h.Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	f, err := fsys.Open(file.name)
+	if err != nil {
+		http.Error(w, "File unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	h.Set("Content-Encoding", "gzip")
+	// Embedded files have no modification time
+	if !file.modTime.IsZero() {
+		h.Set("Last-Modified", file.modTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	gz := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gz)
+	gz.Reset(w)
+	io.Copy(gz, f)
+	gz.Close()
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows
+// encoding with a non-zero quality.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
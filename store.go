@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	errEmptyInsert        = errors.New("insert returned no rows")
+	errStoreNotConfigured = errors.New("storage backend not configured")
+)
+
+// dataStore is the storage backend used by the server. The frontend has the
+// same abstraction in src/lib/dataService.ts; here it lets self-hosters run
+// against a plain Postgres database instead of Supabase REST.
+type dataStore interface {
+	// ping verifies the backend is reachable.
+	ping(ctx context.Context) error
+
+	// API keys
+	findAPIKeyByHash(ctx context.Context, keyHash string) (*apiKeyRecord, error)
+	touchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+	listAPIKeys(ctx context.Context, budgetID string) ([]apiKeyRecord, error)
+	createAPIKey(ctx context.Context, key apiKeyRecord) (*apiKeyRecord, error)
+	// deleteAPIKey reports whether a key was deleted.
+	deleteAPIKey(ctx context.Context, id string) (bool, error)
+
+	// Budgets; nil when not found
+	getBudget(ctx context.Context, budgetID string) (*budgetRecord, error)
+
+	// Accounts
+	listOpenAccounts(ctx context.Context, budgetID string) ([]accountRecord, error)
+	createAccount(ctx context.Context, account accountRecord, sortOrder int) (*accountRecord, error)
+	updateAccountBalance(ctx context.Context, id string, balance float64) error
+
+	// Categories and learned payee rules
+	listCategories(ctx context.Context, budgetID string) ([]categoryRecord, error)
+	listPayeeCategoryRules(ctx context.Context, budgetID string) ([]payeeCategoryRuleRecord, error)
+	upsertPayeeCategoryRule(ctx context.Context, budgetID, payeeName, categoryID string) error
+
+	// Payees
+	findPayeeByName(ctx context.Context, budgetID, name string) (*payeeRecord, error)
+	createPayee(ctx context.Context, budgetID, name string) (*payeeRecord, error)
+
+	// Transactions
+	createTransaction(ctx context.Context, tx transactionInput) (*transactionRecord, error)
+	// listTransactions returns every transaction in the budget, oldest first.
+	listTransactions(ctx context.Context, budgetID string) ([]exportedTransaction, error)
+
+	// Scheduled transactions due on or before a date, in open accounts
+	listDueScheduledTransactions(ctx context.Context, through string) ([]scheduledTransactionRecord, error)
+	// postScheduledTransaction moves the schedule on from date to next and
+	// inserts the transaction for date, adjusting the account balance. It
+	// reports false, posting nothing, when the schedule is no longer at date
+	// because another runner got there first; true with an error means the
+	// transaction was posted but the balance was not updated.
+	postScheduledTransaction(ctx context.Context, scheduled scheduledTransactionRecord, date, next string) (bool, error)
+
+	// Net worth history
+	listAllOpenAccounts(ctx context.Context) ([]accountRecord, error)
+	hasNetWorthSnapshots(ctx context.Context, budgetID string) (bool, error)
+	// listTransactionAmounts returns the date and amount of every
+	// transaction in the budget's accounts, newest first.
+	listTransactionAmounts(ctx context.Context, budgetID string) ([]transactionAmount, error)
+	// upsertNetWorthSnapshots writes snapshots, replacing any already
+	// stored for the same budget and date.
+	upsertNetWorthSnapshots(ctx context.Context, snapshots []netWorthSnapshot) error
+
+	// Monthly category budgets
+	listCategoryTargets(ctx context.Context) ([]categoryTarget, error)
+	listMonthlyBudgets(ctx context.Context, budgetID string, months []string) ([]monthlyBudgetRecord, error)
+	// listCategoryActivity totals categorised transactions in on-budget
+	// accounts per category and month, for dates from from up to to.
+	listCategoryActivity(ctx context.Context, budgetID, from, to string) ([]categoryActivity, error)
+	// insertMonthlyBudgets creates rows, leaving any that already exist.
+	insertMonthlyBudgets(ctx context.Context, rows []monthlyBudgetRecord) error
+	// updateMonthlyBudgetBalances sets carryover, activity and available,
+	// never budgeted, which belongs to the user.
+	updateMonthlyBudgetBalances(ctx context.Context, rows []monthlyBudgetRecord) error
+
+	// Notifications shown in the app's notification bell
+	createNotification(ctx context.Context, notification notificationInput) error
+}
+
+// transactionInput holds the fields needed to insert a transaction.
+// Empty IDs are stored as NULL.
+type transactionInput struct {
+	AccountID         string
+	CategoryID        string
+	PayeeID           string
+	TransferAccountID string
+	Date              string
+	Amount            float64
+	Memo              string
+	Cleared           bool
+	Approved          bool
+}
+
+// exportedTransaction is a transaction with its references resolved to
+// names, as written by the export command.
+type exportedTransaction struct {
+	ID       string  `json:"id"`
+	Date     string  `json:"date"`
+	Account  string  `json:"account"`
+	Payee    string  `json:"payee,omitempty"`
+	Category string  `json:"category,omitempty"`
+	Memo     string  `json:"memo,omitempty"`
+	Amount   float64 `json:"amount"`
+	Cleared  bool    `json:"cleared"`
+}
+
+// scheduledTransactionRecord is a scheduled transaction with the budget
+// and names the scheduler needs to post it and tell the owner.
+type scheduledTransactionRecord struct {
+	ID         string
+	AccountID  string
+	CategoryID string
+	PayeeID    string
+	Frequency  string // daily, weekly, biweekly, monthly or yearly
+	NextDate   string
+	// Day of month monthly and yearly schedules fall on, so a schedule
+	// clamped to a short month returns to it; 0 until first posted
+	AnchorDay   int
+	Amount      float64
+	Memo        string
+	BudgetID    string
+	UserID      string
+	AccountName string
+	PayeeName   string
+}
+
+type transactionAmount struct {
+	AccountID string  `json:"account_id"`
+	Date      string  `json:"date"`
+	Amount    float64 `json:"amount"`
+}
+
+type netWorthSnapshot struct {
+	BudgetID    string  `json:"budget_id"`
+	Date        string  `json:"date"`
+	Assets      float64 `json:"assets"`
+	Liabilities float64 `json:"liabilities"`
+	NetWorth    float64 `json:"net_worth"`
+}
+
+// categoryTarget is a category with the budget it belongs to and the
+// funding target set on it, if any.
+type categoryTarget struct {
+	ID           string
+	BudgetID     string
+	TargetType   string
+	TargetAmount float64
+	TargetDate   string
+}
+
+type monthlyBudgetRecord struct {
+	CategoryID string  `json:"category_id"`
+	Month      string  `json:"month"`
+	Budgeted   float64 `json:"budgeted"`
+	Activity   float64 `json:"activity"`
+	Available  float64 `json:"available"`
+	Carryover  float64 `json:"carryover"`
+}
+
+type categoryActivity struct {
+	CategoryID string
+	Month      string
+	Activity   float64
+	// Outflows charged to credit cards, as a positive amount
+	CreditSpending float64
+}
+
+type notificationInput struct {
+	UserID  string
+	Type    string // success, transaction_failed, scheduled_transaction or info
+	Title   string
+	Message string
+}
+
+type storageConfig struct {
+	Backend     string // supabase or postgres
+	DatabaseURL string // for postgres
+}
+
+func loadStorageConfig(src *configSource) storageConfig {
+	return storageConfig{
+		Backend:     src.getEnvEnum("STORAGE_BACKEND", "supabase", "supabase", "postgres"),
+		DatabaseURL: src.getEnv("DATABASE_URL", ""),
+	}
+}
+
+// newDataStore builds the storage backend selected by STORAGE_BACKEND.
+func newDataStore(cfg storageConfig, supabase supabaseConfig) (dataStore, error) {
+	switch cfg.Backend {
+	case "supabase", "":
+		if supabase.URL == "" || supabase.ServiceRoleKey == "" {
+			return nil, fmt.Errorf("%w: supabase service role key not set", errStoreNotConfigured)
+		}
+		return newSupabaseClient(supabase), nil
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for the postgres storage backend")
+		}
+		pg, err := newPostgresStore(cfg.DatabaseURL)
+		if err != nil {
+			return nil, err
+		}
+		return pg, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// closeStore releases backends that hold connections.
+func closeStore(store dataStore) {
+	if closer, ok := store.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// nullableString maps empty IDs to NULL for inserts.
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresStore implements dataStore against the YABT schema directly,
+// for self-hosted deployments without Supabase. Row level security is not
+// applied on this path; the server scopes every query by budget itself.
+type postgresStore struct {
+	db *sql.DB
+}
+
+func newPostgresStore(dsn string) (*postgresStore, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close releases the connection pool at shutdown.
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *postgresStore) findAPIKeyByHash(ctx context.Context, keyHash string) (*apiKeyRecord, error) {
+	var record apiKeyRecord
+	err := s.db.QueryRowContext(ctx,
+		`select id::text, user_id::text, budget_id::text, name
+		 from api_keys where key_hash = $1 limit 1`,
+		keyHash,
+	).Scan(&record.ID, &record.UserID, &record.BudgetID, &record.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *postgresStore) touchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `update api_keys set last_used_at = $2 where id = $1`, id, usedAt.UTC())
+	return err
+}
+
+func (s *postgresStore) listAPIKeys(ctx context.Context, budgetID string) ([]apiKeyRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select id::text, user_id::text, budget_id::text, name, last_used_at, created_at
+		 from api_keys where budget_id = $1 order by created_at`,
+		budgetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []apiKeyRecord
+	for rows.Next() {
+		var key apiKeyRecord
+		var lastUsedAt sql.NullTime
+		var createdAt time.Time
+		if err := rows.Scan(&key.ID, &key.UserID, &key.BudgetID, &key.Name, &lastUsedAt, &createdAt); err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			used := lastUsedAt.Time.UTC().Format(time.RFC3339)
+			key.LastUsedAt = &used
+		}
+		key.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresStore) createAPIKey(ctx context.Context, key apiKeyRecord) (*apiKeyRecord, error) {
+	created := key
+	var createdAt time.Time
+	err := s.db.QueryRowContext(ctx,
+		`insert into api_keys (user_id, budget_id, name, key_hash)
+		 values ($1, $2, $3, $4)
+		 returning id::text, created_at`,
+		key.UserID, key.BudgetID, key.Name, key.KeyHash,
+	).Scan(&created.ID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	created.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	return &created, nil
+}
+
+func (s *postgresStore) deleteAPIKey(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `delete from api_keys where id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *postgresStore) getBudget(ctx context.Context, budgetID string) (*budgetRecord, error) {
+	var record budgetRecord
+	var features []byte
+	err := s.db.QueryRowContext(ctx,
+		`select id::text, user_id::text, coalesce(features, '{}'::jsonb)
+		 from budgets where id = $1`,
+		budgetID,
+	).Scan(&record.ID, &record.UserID, &features)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(features, &record.Features); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *postgresStore) listOpenAccounts(ctx context.Context, budgetID string) ([]accountRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select id::text, budget_id::text, name, account_type,
+		        coalesce(balance, 0)::float8, coalesce(is_on_budget, true), coalesce(closed, false)
+		 from accounts where budget_id = $1 and coalesce(closed, false) = false`,
+		budgetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []accountRecord
+	for rows.Next() {
+		var account accountRecord
+		if err := rows.Scan(&account.ID, &account.BudgetID, &account.Name, &account.AccountType,
+			&account.Balance, &account.IsOnBudget, &account.Closed); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (s *postgresStore) createAccount(ctx context.Context, account accountRecord, sortOrder int) (*accountRecord, error) {
+	created := account
+	err := s.db.QueryRowContext(ctx,
+		`insert into accounts (budget_id, name, account_type, balance, is_on_budget, closed, sort_order)
+		 values ($1, $2, $3, $4, $5, $6, $7)
+		 returning id::text`,
+		account.BudgetID, account.Name, account.AccountType, account.Balance,
+		account.IsOnBudget, account.Closed, sortOrder,
+	).Scan(&created.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (s *postgresStore) updateAccountBalance(ctx context.Context, id string, balance float64) error {
+	_, err := s.db.ExecContext(ctx, `update accounts set balance = $2, updated_at = now() where id = $1`, id, balance)
+	return err
+}
+
+func (s *postgresStore) listCategories(ctx context.Context, budgetID string) ([]categoryRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select c.id::text, c.name, c.category_group_id::text
+		 from categories c
+		 join category_groups cg on cg.id = c.category_group_id
+		 where cg.budget_id = $1`,
+		budgetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []categoryRecord
+	for rows.Next() {
+		var category categoryRecord
+		if err := rows.Scan(&category.ID, &category.Name, &category.CategoryGroupID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (s *postgresStore) listPayeeCategoryRules(ctx context.Context, budgetID string) ([]payeeCategoryRuleRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select payee_name, category_id::text from payee_category_rules where budget_id = $1`,
+		budgetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []payeeCategoryRuleRecord
+	for rows.Next() {
+		var rule payeeCategoryRuleRecord
+		if err := rows.Scan(&rule.PayeeName, &rule.CategoryID); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *postgresStore) upsertPayeeCategoryRule(ctx context.Context, budgetID, payeeName, categoryID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`insert into payee_category_rules (budget_id, payee_name, category_id)
+		 values ($1, $2, $3)
+		 on conflict (budget_id, payee_name) do update set category_id = excluded.category_id`,
+		budgetID, payeeName, categoryID,
+	)
+	return err
+}
+
+func (s *postgresStore) findPayeeByName(ctx context.Context, budgetID, name string) (*payeeRecord, error) {
+	var payee payeeRecord
+	err := s.db.QueryRowContext(ctx,
+		`select id::text, name from payees where budget_id = $1 and name ilike $2 limit 1`,
+		budgetID, name,
+	).Scan(&payee.ID, &payee.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payee, nil
+}
+
+func (s *postgresStore) createPayee(ctx context.Context, budgetID, name string) (*payeeRecord, error) {
+	payee := payeeRecord{Name: name}
+	err := s.db.QueryRowContext(ctx,
+		`insert into payees (budget_id, name) values ($1, $2) returning id::text`,
+		budgetID, name,
+	).Scan(&payee.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &payee, nil
+}
+
+func (s *postgresStore) createTransaction(ctx context.Context, tx transactionInput) (*transactionRecord, error) {
+	var record transactionRecord
+	err := s.db.QueryRowContext(ctx,
+		`insert into transactions
+		   (account_id, category_id, payee_id, transfer_account_id, date, amount, memo, cleared, approved)
+		 values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 returning id::text`,
+		tx.AccountID, nullableString(tx.CategoryID), nullableString(tx.PayeeID),
+		nullableString(tx.TransferAccountID), tx.Date, tx.Amount, tx.Memo, tx.Cleared, tx.Approved,
+	).Scan(&record.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *postgresStore) listTransactions(ctx context.Context, budgetID string) ([]exportedTransaction, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select t.id::text, t.date::text, a.name, coalesce(p.name, ''), coalesce(c.name, ''),
+		        coalesce(t.memo, ''), t.amount::float8, coalesce(t.cleared, false)
+		 from transactions t
+		 join accounts a on a.id = t.account_id
+		 left join payees p on p.id = t.payee_id
+		 left join categories c on c.id = t.category_id
+		 where a.budget_id = $1
+		 order by t.date, t.created_at`,
+		budgetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []exportedTransaction
+	for rows.Next() {
+		var tx exportedTransaction
+		if err := rows.Scan(&tx.ID, &tx.Date, &tx.Account, &tx.Payee, &tx.Category,
+			&tx.Memo, &tx.Amount, &tx.Cleared); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions, rows.Err()
+}
+
+func (s *postgresStore) listDueScheduledTransactions(ctx context.Context, through string) ([]scheduledTransactionRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select st.id::text, st.account_id::text, coalesce(st.category_id::text, ''), coalesce(st.payee_id::text, ''),
+		        st.frequency, st.next_date::text, coalesce(st.anchor_day, 0), st.amount::float8, coalesce(st.memo, ''),
+		        a.budget_id::text, b.user_id::text, a.name, coalesce(p.name, '')
+		 from scheduled_transactions st
+		 join accounts a on a.id = st.account_id
+		 join budgets b on b.id = a.budget_id
+		 left join payees p on p.id = st.payee_id
+		 where st.next_date <= $1 and coalesce(a.closed, false) = false
+		 order by st.next_date, st.id`,
+		through,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scheduled []scheduledTransactionRecord
+	for rows.Next() {
+		var st scheduledTransactionRecord
+		if err := rows.Scan(&st.ID, &st.AccountID, &st.CategoryID, &st.PayeeID,
+			&st.Frequency, &st.NextDate, &st.AnchorDay, &st.Amount, &st.Memo,
+			&st.BudgetID, &st.UserID, &st.AccountName, &st.PayeeName); err != nil {
+			return nil, err
+		}
+		scheduled = append(scheduled, st)
+	}
+	return scheduled, rows.Err()
+}
+
+// postScheduledTransaction claims, posts and adjusts the balance in one
+// database transaction, so each occurrence is posted exactly once.
+func (s *postgresStore) postScheduledTransaction(ctx context.Context, scheduled scheduledTransactionRecord, date, next string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`update scheduled_transactions
+		 set next_date = $3, anchor_day = $4, updated_at = now()
+		 where id = $1 and next_date = $2`,
+		scheduled.ID, date, next, scheduled.AnchorDay,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`insert into transactions (account_id, category_id, payee_id, date, amount, memo, cleared, approved)
+		 values ($1, $2, $3, $4, $5, $6, false, true)`,
+		scheduled.AccountID, nullableString(scheduled.CategoryID), nullableString(scheduled.PayeeID),
+		date, scheduled.Amount, scheduled.Memo,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`update accounts set balance = coalesce(balance, 0) + $2, updated_at = now() where id = $1`,
+		scheduled.AccountID, scheduled.Amount,
+	); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *postgresStore) listAllOpenAccounts(ctx context.Context) ([]accountRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select id::text, budget_id::text, name, account_type, coalesce(balance, 0)::float8,
+		        coalesce(is_on_budget, true), coalesce(closed, false)
+		 from accounts
+		 where coalesce(closed, false) = false
+		 order by budget_id, id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []accountRecord
+	for rows.Next() {
+		var account accountRecord
+		if err := rows.Scan(&account.ID, &account.BudgetID, &account.Name, &account.AccountType,
+			&account.Balance, &account.IsOnBudget, &account.Closed); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (s *postgresStore) hasNetWorthSnapshots(ctx context.Context, budgetID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		`select exists (select 1 from net_worth_snapshots where budget_id = $1)`,
+		budgetID,
+	).Scan(&exists)
+	return exists, err
+}
+
+func (s *postgresStore) listTransactionAmounts(ctx context.Context, budgetID string) ([]transactionAmount, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select t.account_id::text, t.date::text, t.amount::float8
+		 from transactions t
+		 join accounts a on a.id = t.account_id
+		 where a.budget_id = $1
+		 order by t.date desc`,
+		budgetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amounts []transactionAmount
+	for rows.Next() {
+		var amount transactionAmount
+		if err := rows.Scan(&amount.AccountID, &amount.Date, &amount.Amount); err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, amount)
+	}
+	return amounts, rows.Err()
+}
+
+func (s *postgresStore) upsertNetWorthSnapshots(ctx context.Context, snapshots []netWorthSnapshot) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, snapshot := range snapshots {
+		if _, err := tx.ExecContext(ctx,
+			`insert into net_worth_snapshots (budget_id, date, assets, liabilities, net_worth)
+			 values ($1, $2, $3, $4, $5)
+			 on conflict (budget_id, date) do update
+			 set assets = excluded.assets, liabilities = excluded.liabilities, net_worth = excluded.net_worth`,
+			snapshot.BudgetID, snapshot.Date, snapshot.Assets, snapshot.Liabilities, snapshot.NetWorth,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) listCategoryTargets(ctx context.Context) ([]categoryTarget, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select c.id::text, cg.budget_id::text, coalesce(c.target_type, 'none'),
+		        coalesce(c.target_amount, 0)::float8, coalesce(c.target_date::text, '')
+		 from categories c
+		 join category_groups cg on cg.id = c.category_group_id
+		 order by cg.budget_id, c.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []categoryTarget
+	for rows.Next() {
+		var target categoryTarget
+		if err := rows.Scan(&target.ID, &target.BudgetID, &target.TargetType, &target.TargetAmount, &target.TargetDate); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+func (s *postgresStore) listMonthlyBudgets(ctx context.Context, budgetID string, months []string) ([]monthlyBudgetRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select mb.category_id::text, mb.month::text, coalesce(mb.budgeted, 0)::float8,
+		        coalesce(mb.activity, 0)::float8, coalesce(mb.available, 0)::float8, mb.carryover::float8
+		 from monthly_budgets mb
+		 join categories c on c.id = mb.category_id
+		 join category_groups cg on cg.id = c.category_group_id
+		 where cg.budget_id = $1 and mb.month::text = any($2)`,
+		budgetID, months,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []monthlyBudgetRecord
+	for rows.Next() {
+		var mb monthlyBudgetRecord
+		if err := rows.Scan(&mb.CategoryID, &mb.Month, &mb.Budgeted, &mb.Activity, &mb.Available, &mb.Carryover); err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, mb)
+	}
+	return budgets, rows.Err()
+}
+
+func (s *postgresStore) listCategoryActivity(ctx context.Context, budgetID, from, to string) ([]categoryActivity, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`select t.category_id::text, date_trunc('month', t.date)::date::text, sum(t.amount)::float8,
+		        coalesce(-sum(t.amount) filter (where a.account_type = 'credit_card' and t.amount < 0), 0)::float8
+		 from transactions t
+		 join accounts a on a.id = t.account_id
+		 where a.budget_id = $1 and a.is_on_budget = true and t.category_id is not null
+		   and t.date >= $2 and t.date < $3
+		 group by 1, 2`,
+		budgetID, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activity []categoryActivity
+	for rows.Next() {
+		var a categoryActivity
+		if err := rows.Scan(&a.CategoryID, &a.Month, &a.Activity, &a.CreditSpending); err != nil {
+			return nil, err
+		}
+		activity = append(activity, a)
+	}
+	return activity, rows.Err()
+}
+
+func (s *postgresStore) insertMonthlyBudgets(ctx context.Context, rows []monthlyBudgetRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, mb := range rows {
+		if _, err := tx.ExecContext(ctx,
+			`insert into monthly_budgets (category_id, month, budgeted, activity, available, carryover)
+			 values ($1, $2, $3, $4, $5, $6)
+			 on conflict (category_id, month) do nothing`,
+			mb.CategoryID, mb.Month, mb.Budgeted, mb.Activity, mb.Available, mb.Carryover,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateMonthlyBudgetBalances recomputes available from the stored
+// budgeted, so a concurrent edit by the user is never lost.
+func (s *postgresStore) updateMonthlyBudgetBalances(ctx context.Context, rows []monthlyBudgetRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, mb := range rows {
+		if _, err := tx.ExecContext(ctx,
+			`update monthly_budgets
+			 set carryover = $3, activity = $4, available = $3 + coalesce(budgeted, 0) + $4, updated_at = now()
+			 where category_id = $1 and month = $2`,
+			mb.CategoryID, mb.Month, mb.Carryover, mb.Activity,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresStore) createNotification(ctx context.Context, notification notificationInput) error {
+	_, err := s.db.ExecContext(ctx,
+		`insert into notifications (user_id, type, title, message) values ($1, $2, $3, $4)`,
+		notification.UserID, notification.Type, notification.Title, nullableString(notification.Message),
+	)
+	return err
+}
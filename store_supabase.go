@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supabaseClient implements dataStore over the Supabase REST API using the
+// service role key.
+
+func (c *supabaseClient) ping(ctx context.Context) error {
+	query := url.Values{}
+	query.Set("select", "id")
+	query.Set("limit", "1")
+	return c.request(ctx, "GET", "api_keys", query, nil, nil)
+}
+
+func (c *supabaseClient) findAPIKeyByHash(ctx context.Context, keyHash string) (*apiKeyRecord, error) {
+	query := url.Values{}
+	query.Set("key_hash", "eq."+keyHash)
+	query.Set("select", "id,user_id,budget_id,name")
+
+	var records []apiKeyRecord
+	if err := c.request(ctx, "GET", "api_keys", query, nil, &records); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+func (c *supabaseClient) touchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	query := url.Values{}
+	query.Set("id", "eq."+id)
+	return c.request(ctx, "PATCH", "api_keys", query, map[string]interface{}{
+		"last_used_at": usedAt.UTC().Format(time.RFC3339),
+	}, nil)
+}
+
+func (c *supabaseClient) listAPIKeys(ctx context.Context, budgetID string) ([]apiKeyRecord, error) {
+	query := url.Values{}
+	query.Set("budget_id", "eq."+budgetID)
+	query.Set("select", "id,user_id,budget_id,name,last_used_at,created_at")
+	query.Set("order", "created_at.asc")
+
+	var keys []apiKeyRecord
+	if err := c.request(ctx, "GET", "api_keys", query, nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *supabaseClient) createAPIKey(ctx context.Context, key apiKeyRecord) (*apiKeyRecord, error) {
+	payload := map[string]interface{}{
+		"user_id":   key.UserID,
+		"budget_id": key.BudgetID,
+		"name":      key.Name,
+		"key_hash":  key.KeyHash,
+	}
+
+	var created []apiKeyRecord
+	if err := c.request(ctx, "POST", "api_keys", nil, payload, &created); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 {
+		return nil, errEmptyInsert
+	}
+	return &created[0], nil
+}
+
+func (c *supabaseClient) deleteAPIKey(ctx context.Context, id string) (bool, error) {
+	query := url.Values{}
+	query.Set("id", "eq."+id)
+	query.Set("select", "id")
+
+	var deleted []apiKeyRecord
+	if err := c.requestWithPrefer(ctx, "DELETE", "api_keys", query, nil, &deleted, "return=representation"); err != nil {
+		return false, err
+	}
+	return len(deleted) > 0, nil
+}
+
+func (c *supabaseClient) getBudget(ctx context.Context, budgetID string) (*budgetRecord, error) {
+	query := url.Values{}
+	query.Set("id", "eq."+budgetID)
+	query.Set("select", "id,user_id,features")
+
+	var records []budgetRecord
+	if err := c.request(ctx, "GET", "budgets", query, nil, &records); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+func (c *supabaseClient) listOpenAccounts(ctx context.Context, budgetID string) ([]accountRecord, error) {
+	query := url.Values{}
+	query.Set("budget_id", "eq."+budgetID)
+	query.Set("closed", "not.is.true")
+	query.Set("select", "id,budget_id,name,account_type,balance,is_on_budget,closed")
+
+	var accounts []accountRecord
+	if err := c.request(ctx, "GET", "accounts", query, nil, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (c *supabaseClient) createAccount(ctx context.Context, account accountRecord, sortOrder int) (*accountRecord, error) {
+	payload := map[string]interface{}{
+		"budget_id":    account.BudgetID,
+		"name":         account.Name,
+		"account_type": account.AccountType,
+		"balance":      account.Balance,
+		"is_on_budget": account.IsOnBudget,
+		"closed":       account.Closed,
+		"sort_order":   sortOrder,
+	}
+
+	var created []accountRecord
+	if err := c.request(ctx, "POST", "accounts", nil, payload, &created); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 {
+		return nil, errEmptyInsert
+	}
+	return &created[0], nil
+}
+
+func (c *supabaseClient) updateAccountBalance(ctx context.Context, id string, balance float64) error {
+	query := url.Values{}
+	query.Set("id", "eq."+id)
+	return c.request(ctx, "PATCH", "accounts", query, map[string]interface{}{
+		"balance": balance,
+	}, nil)
+}
+
+func (c *supabaseClient) listCategories(ctx context.Context, budgetID string) ([]categoryRecord, error) {
+	groupQuery := url.Values{}
+	groupQuery.Set("budget_id", "eq."+budgetID)
+	groupQuery.Set("select", "id")
+
+	var groups []categoryGroupRecord
+	if err := c.request(ctx, "GET", "category_groups", groupQuery, nil, &groups); err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.ID)
+	}
+
+	categoryQuery := url.Values{}
+	categoryQuery.Set("category_group_id", "in.("+strings.Join(groupIDs, ",")+")")
+	categoryQuery.Set("select", "id,name,category_group_id")
+
+	var categories []categoryRecord
+	if err := c.request(ctx, "GET", "categories", categoryQuery, nil, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (c *supabaseClient) listPayeeCategoryRules(ctx context.Context, budgetID string) ([]payeeCategoryRuleRecord, error) {
+	query := url.Values{}
+	query.Set("budget_id", "eq."+budgetID)
+	query.Set("select", "payee_name,category_id")
+
+	var rules []payeeCategoryRuleRecord
+	if err := c.request(ctx, "GET", "payee_category_rules", query, nil, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (c *supabaseClient) upsertPayeeCategoryRule(ctx context.Context, budgetID, payeeName, categoryID string) error {
+	payload := map[string]interface{}{
+		"budget_id":   budgetID,
+		"payee_name":  payeeName,
+		"category_id": categoryID,
+	}
+	query := url.Values{}
+	query.Set("on_conflict", "budget_id,payee_name")
+	return c.requestWithPrefer(ctx, "POST", "payee_category_rules", query, payload, nil, "resolution=merge-duplicates")
+}
+
+func (c *supabaseClient) findPayeeByName(ctx context.Context, budgetID, name string) (*payeeRecord, error) {
+	query := url.Values{}
+	query.Set("budget_id", "eq."+budgetID)
+	query.Set("name", "ilike."+name)
+	query.Set("select", "id,name")
+
+	var payees []payeeRecord
+	if err := c.request(ctx, "GET", "payees", query, nil, &payees); err != nil {
+		return nil, err
+	}
+	if len(payees) == 0 {
+		return nil, nil
+	}
+	return &payees[0], nil
+}
+
+func (c *supabaseClient) createPayee(ctx context.Context, budgetID, name string) (*payeeRecord, error) {
+	payload := map[string]interface{}{
+		"budget_id": budgetID,
+		"name":      name,
+	}
+
+	var created []payeeRecord
+	if err := c.request(ctx, "POST", "payees", nil, payload, &created); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 {
+		return nil, errEmptyInsert
+	}
+	return &created[0], nil
+}
+
+func (c *supabaseClient) createTransaction(ctx context.Context, tx transactionInput) (*transactionRecord, error) {
+	payload := map[string]interface{}{
+		"account_id":          tx.AccountID,
+		"category_id":         nullableString(tx.CategoryID),
+		"payee_id":            nullableString(tx.PayeeID),
+		"transfer_account_id": nullableString(tx.TransferAccountID),
+		"date":                tx.Date,
+		"amount":              tx.Amount,
+		"memo":                tx.Memo,
+		"cleared":             tx.Cleared,
+		"approved":            tx.Approved,
+	}
+
+	var created []transactionRecord
+	if err := c.request(ctx, "POST", "transactions", nil, payload, &created); err != nil {
+		return nil, err
+	}
+	if len(created) == 0 {
+		return &transactionRecord{}, nil
+	}
+	return &created[0], nil
+}
+
+func (c *supabaseClient) listTransactions(ctx context.Context, budgetID string) ([]exportedTransaction, error) {
+	accountQuery := url.Values{}
+	accountQuery.Set("budget_id", "eq."+budgetID)
+	accountQuery.Set("select", "id,name")
+	accounts, err := supabaseGetAll[accountRecord](ctx, c, "accounts", accountQuery)
+	if err != nil || len(accounts) == 0 {
+		return nil, err
+	}
+
+	payeeQuery := url.Values{}
+	payeeQuery.Set("budget_id", "eq."+budgetID)
+	payeeQuery.Set("select", "id,name")
+	payees, err := supabaseGetAll[payeeRecord](ctx, c, "payees", payeeQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := c.listCategories(ctx, budgetID)
+	if err != nil {
+		return nil, err
+	}
+
+	accountNames := make(map[string]string, len(accounts))
+	accountIDs := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		accountNames[account.ID] = account.Name
+		accountIDs = append(accountIDs, account.ID)
+	}
+	payeeNames := make(map[string]string, len(payees))
+	for _, payee := range payees {
+		payeeNames[payee.ID] = payee.Name
+	}
+	categoryNames := make(map[string]string, len(categories))
+	for _, category := range categories {
+		categoryNames[category.ID] = category.Name
+	}
+
+	txQuery := url.Values{}
+	txQuery.Set("account_id", "in.("+strings.Join(accountIDs, ",")+")")
+	txQuery.Set("select", "id,account_id,payee_id,category_id,date,amount,memo,cleared")
+	txQuery.Set("order", "date.asc,created_at.asc,id.asc")
+	rows, err := supabaseGetAll[struct {
+		ID         string  `json:"id"`
+		AccountID  string  `json:"account_id"`
+		PayeeID    *string `json:"payee_id"`
+		CategoryID *string `json:"category_id"`
+		Date       string  `json:"date"`
+		Amount     float64 `json:"amount"`
+		Memo       *string `json:"memo"`
+		Cleared    *bool   `json:"cleared"`
+	}](ctx, c, "transactions", txQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]exportedTransaction, 0, len(rows))
+	for _, row := range rows {
+		tx := exportedTransaction{
+			ID:      row.ID,
+			Date:    row.Date,
+			Account: accountNames[row.AccountID],
+			Amount:  row.Amount,
+		}
+		if row.PayeeID != nil {
+			tx.Payee = payeeNames[*row.PayeeID]
+		}
+		if row.CategoryID != nil {
+			tx.Category = categoryNames[*row.CategoryID]
+		}
+		if row.Memo != nil {
+			tx.Memo = *row.Memo
+		}
+		if row.Cleared != nil {
+			tx.Cleared = *row.Cleared
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions, nil
+}
+
+// listDueScheduledTransactions embeds each schedule's account, budget owner
+// and payee, so the lookup stays a single request however many schedules
+// are due.
+func (c *supabaseClient) listDueScheduledTransactions(ctx context.Context, through string) ([]scheduledTransactionRecord, error) {
+	query := url.Values{}
+	query.Set("next_date", "lte."+through)
+	query.Set("accounts.closed", "not.is.true")
+	query.Set("select", "id,account_id,category_id,payee_id,frequency,next_date,anchor_day,amount,memo,"+
+		"accounts!inner(budget_id,name,budgets(user_id)),payees(name)")
+	query.Set("order", "next_date.asc,id.asc")
+	rows, err := supabaseGetAll[struct {
+		ID         string  `json:"id"`
+		AccountID  string  `json:"account_id"`
+		CategoryID *string `json:"category_id"`
+		PayeeID    *string `json:"payee_id"`
+		Frequency  string  `json:"frequency"`
+		NextDate   string  `json:"next_date"`
+		AnchorDay  *int    `json:"anchor_day"`
+		Amount     float64 `json:"amount"`
+		Memo       *string `json:"memo"`
+		Account    struct {
+			BudgetID string `json:"budget_id"`
+			Name     string `json:"name"`
+			Budget   *struct {
+				UserID string `json:"user_id"`
+			} `json:"budgets"`
+		} `json:"accounts"`
+		Payee *struct {
+			Name string `json:"name"`
+		} `json:"payees"`
+	}](ctx, c, "scheduled_transactions", query)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := make([]scheduledTransactionRecord, 0, len(rows))
+	for _, row := range rows {
+		st := scheduledTransactionRecord{
+			ID:          row.ID,
+			AccountID:   row.AccountID,
+			Frequency:   row.Frequency,
+			NextDate:    row.NextDate,
+			Amount:      row.Amount,
+			BudgetID:    row.Account.BudgetID,
+			AccountName: row.Account.Name,
+		}
+		if row.Account.Budget != nil {
+			st.UserID = row.Account.Budget.UserID
+		}
+		if row.CategoryID != nil {
+			st.CategoryID = *row.CategoryID
+		}
+		if row.PayeeID != nil {
+			st.PayeeID = *row.PayeeID
+		}
+		if row.Payee != nil {
+			st.PayeeName = row.Payee.Name
+		}
+		if row.AnchorDay != nil {
+			st.AnchorDay = *row.AnchorDay
+		}
+		if row.Memo != nil {
+			st.Memo = *row.Memo
+		}
+		scheduled = append(scheduled, st)
+	}
+	return scheduled, nil
+}
+
+// postScheduledTransaction calls post_scheduled_transaction, which claims
+// the occurrence by moving next_date on only while it still equals date,
+// inserts the transaction and adjusts the account balance in one database
+// transaction. It returns null when another runner claimed it first.
+func (c *supabaseClient) postScheduledTransaction(ctx context.Context, scheduled scheduledTransactionRecord, date, next string) (bool, error) {
+	var transactionID *string
+	if err := c.request(ctx, "POST", "rpc/post_scheduled_transaction", nil, map[string]interface{}{
+		"p_scheduled_id": scheduled.ID,
+		"p_date":         date,
+		"p_next_date":    next,
+		"p_anchor_day":   scheduled.AnchorDay,
+	}, &transactionID); err != nil {
+		return false, err
+	}
+	return transactionID != nil, nil
+}
+
+func (c *supabaseClient) listAllOpenAccounts(ctx context.Context) ([]accountRecord, error) {
+	query := url.Values{}
+	query.Set("closed", "not.is.true")
+	query.Set("select", "id,budget_id,name,account_type,balance,is_on_budget,closed")
+	return supabaseGetAll[accountRecord](ctx, c, "accounts", query)
+}
+
+func (c *supabaseClient) hasNetWorthSnapshots(ctx context.Context, budgetID string) (bool, error) {
+	query := url.Values{}
+	query.Set("budget_id", "eq."+budgetID)
+	query.Set("select", "id")
+	query.Set("limit", "1")
+
+	var snapshots []struct {
+		ID string `json:"id"`
+	}
+	if err := c.request(ctx, "GET", "net_worth_snapshots", query, nil, &snapshots); err != nil {
+		return false, err
+	}
+	return len(snapshots) > 0, nil
+}
+
+// listTransactionAmounts filters on the embedded account, so a budget with
+// many accounts is still one request. transfer_account_id also references
+// accounts, hence the account_id hint.
+func (c *supabaseClient) listTransactionAmounts(ctx context.Context, budgetID string) ([]transactionAmount, error) {
+	query := url.Values{}
+	query.Set("accounts.budget_id", "eq."+budgetID)
+	query.Set("select", "account_id,date,amount,accounts!account_id!inner(budget_id)")
+	query.Set("order", "date.desc,id.asc")
+	return supabaseGetAll[transactionAmount](ctx, c, "transactions", query)
+}
+
+func (c *supabaseClient) upsertNetWorthSnapshots(ctx context.Context, snapshots []netWorthSnapshot) error {
+	query := url.Values{}
+	query.Set("on_conflict", "budget_id,date")
+	return c.requestWithPrefer(ctx, "POST", "net_worth_snapshots", query, snapshots, nil, "resolution=merge-duplicates,return=minimal")
+}
+
+func (c *supabaseClient) listCategoryTargets(ctx context.Context) ([]categoryTarget, error) {
+	groupQuery := url.Values{}
+	groupQuery.Set("select", "id,budget_id")
+	groups, err := supabaseGetAll[struct {
+		ID       string `json:"id"`
+		BudgetID string `json:"budget_id"`
+	}](ctx, c, "category_groups", groupQuery)
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	budgetByGroup := make(map[string]string, len(groups))
+	for _, group := range groups {
+		budgetByGroup[group.ID] = group.BudgetID
+	}
+
+	categoryQuery := url.Values{}
+	categoryQuery.Set("select", "id,category_group_id,target_type,target_amount,target_date")
+	categories, err := supabaseGetAll[struct {
+		ID              string   `json:"id"`
+		CategoryGroupID string   `json:"category_group_id"`
+		TargetType      *string  `json:"target_type"`
+		TargetAmount    *float64 `json:"target_amount"`
+		TargetDate      *string  `json:"target_date"`
+	}](ctx, c, "categories", categoryQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]categoryTarget, 0, len(categories))
+	for _, category := range categories {
+		budgetID, ok := budgetByGroup[category.CategoryGroupID]
+		if !ok {
+			continue
+		}
+		target := categoryTarget{ID: category.ID, BudgetID: budgetID, TargetType: "none"}
+		if category.TargetType != nil {
+			target.TargetType = *category.TargetType
+		}
+		if category.TargetAmount != nil {
+			target.TargetAmount = *category.TargetAmount
+		}
+		if category.TargetDate != nil {
+			target.TargetDate = *category.TargetDate
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// listMonthlyBudgets reaches the budget through the embedded category and
+// group, so it is one request however many categories the budget has.
+func (c *supabaseClient) listMonthlyBudgets(ctx context.Context, budgetID string, months []string) ([]monthlyBudgetRecord, error) {
+	query := url.Values{}
+	query.Set("categories.category_groups.budget_id", "eq."+budgetID)
+	query.Set("month", "in.("+strings.Join(months, ",")+")")
+	query.Set("select", "category_id,month,budgeted,activity,available,carryover,"+
+		"categories!inner(category_groups!inner(budget_id))")
+	return supabaseGetAll[monthlyBudgetRecord](ctx, c, "monthly_budgets", query)
+}
+
+func (c *supabaseClient) listCategoryActivity(ctx context.Context, budgetID, from, to string) ([]categoryActivity, error) {
+	query := url.Values{}
+	query.Set("accounts.budget_id", "eq."+budgetID)
+	query.Set("accounts.is_on_budget", "eq.true")
+	query.Set("category_id", "not.is.null")
+	query.Add("date", "gte."+from)
+	query.Add("date", "lt."+to)
+	query.Set("select", "category_id,date,amount,accounts!account_id!inner(account_type)")
+	rows, err := supabaseGetAll[struct {
+		CategoryID string  `json:"category_id"`
+		Date       string  `json:"date"`
+		Amount     float64 `json:"amount"`
+		Account    struct {
+			AccountType string `json:"account_type"`
+		} `json:"accounts"`
+	}](ctx, c, "transactions", query)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ category, month string }
+	totals := make(map[key]*categoryActivity)
+	var keys []key
+	for _, row := range rows {
+		if len(row.Date) < len(dateLayout) {
+			continue
+		}
+		k := key{row.CategoryID, row.Date[:len("2006-01")] + "-01"}
+		total, ok := totals[k]
+		if !ok {
+			total = &categoryActivity{CategoryID: k.category, Month: k.month}
+			totals[k] = total
+			keys = append(keys, k)
+		}
+		total.Activity += row.Amount
+		if row.Account.AccountType == "credit_card" && row.Amount < 0 {
+			total.CreditSpending -= row.Amount
+		}
+	}
+	activity := make([]categoryActivity, 0, len(keys))
+	for _, k := range keys {
+		activity = append(activity, *totals[k])
+	}
+	return activity, nil
+}
+
+func (c *supabaseClient) insertMonthlyBudgets(ctx context.Context, rows []monthlyBudgetRecord) error {
+	query := url.Values{}
+	query.Set("on_conflict", "category_id,month")
+	return c.requestWithPrefer(ctx, "POST", "monthly_budgets", query, rows, nil, "resolution=ignore-duplicates,return=minimal")
+}
+
+// updateMonthlyBudgetBalances upserts only carryover and activity, so
+// budgeted keeps whatever the user last set. The monthly_budget_available
+// trigger recomputes available from the stored budgeted in the same
+// statement, so a concurrent edit is never lost.
+func (c *supabaseClient) updateMonthlyBudgetBalances(ctx context.Context, rows []monthlyBudgetRecord) error {
+	payload := make([]map[string]interface{}, 0, len(rows))
+	for _, mb := range rows {
+		payload = append(payload, map[string]interface{}{
+			"category_id": mb.CategoryID,
+			"month":       mb.Month,
+			"carryover":   mb.Carryover,
+			"activity":    mb.Activity,
+		})
+	}
+	query := url.Values{}
+	query.Set("on_conflict", "category_id,month")
+	return c.requestWithPrefer(ctx, "POST", "monthly_budgets", query, payload, nil, "resolution=merge-duplicates,return=minimal")
+}
+
+func (c *supabaseClient) createNotification(ctx context.Context, notification notificationInput) error {
+	payload := map[string]interface{}{
+		"user_id": notification.UserID,
+		"type":    notification.Type,
+		"title":   notification.Title,
+		"message": nullableString(notification.Message),
+	}
+	return c.requestWithPrefer(ctx, "POST", "notifications", nil, payload, nil, "return=minimal")
+}
+
+// Rows per request when reading whole tables; matches the max-rows cap
+// Supabase applies to a single response by default
+const supabasePageSize = 1000
+
+// supabaseGetAll reads every row matching query, one page at a time.
+func supabaseGetAll[T any](ctx context.Context, c *supabaseClient, path string, query url.Values) ([]T, error) {
+	var all []T
+	for offset := 0; ; offset += supabasePageSize {
+		page := url.Values{}
+		for key, values := range query {
+			page[key] = values
+		}
+		page.Set("limit", strconv.Itoa(supabasePageSize))
+		page.Set("offset", strconv.Itoa(offset))
+		if page.Get("order") == "" {
+			// Paging needs a stable order
+			page.Set("order", "id.asc")
+		}
+
+		var rows []T
+		if err := c.request(ctx, "GET", path, page, nil, &rows); err != nil {
+			return nil, err
+		}
+		all = append(all, rows...)
+		if len(rows) < supabasePageSize {
+			return all, nil
+		}
+	}
+}
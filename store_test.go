@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+)
+
+// Accounts as stored: closed is NULL on rows that predate the column default
+var openAccountsFixture = []struct {
+	id     string
+	closed *bool
+}{
+	{"open", boolPtr(false)},
+	{"legacy", nil},
+	{"closed", boolPtr(true)},
+}
+
+func boolPtr(value bool) *bool { return &value }
+
+func TestListOpenAccounts(t *testing.T) {
+	backends := map[string]func(t *testing.T) dataStore{
+		"supabase": newFakePostgRESTAccounts,
+		"postgres": newTestPostgresAccounts,
+	}
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			accounts, err := open(t).listOpenAccounts(context.Background(), "b1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, account := range accounts {
+				if account.Closed {
+					t.Errorf("account %s reported closed", account.ID)
+				}
+				ids = append(ids, account.ID)
+			}
+			sort.Strings(ids)
+			if len(ids) != 2 || ids[0] != "legacy" || ids[1] != "open" {
+				t.Errorf("open accounts = %v, want [legacy open]", ids)
+			}
+		})
+	}
+}
+
+// newFakePostgRESTAccounts serves the fixture through the subset of
+// PostgREST's closed=... filters the client may send.
+func newFakePostgRESTAccounts(t *testing.T) dataStore {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("closed")
+		rows := []map[string]interface{}{}
+		for _, account := range openAccountsFixture {
+			var keep bool
+			switch filter {
+			case "eq.false":
+				keep = account.closed != nil && !*account.closed
+			case "not.is.true":
+				keep = account.closed == nil || !*account.closed
+			default:
+				t.Errorf("unexpected closed filter %q", filter)
+			}
+			if keep {
+				rows = append(rows, map[string]interface{}{"id": account.id, "budget_id": "b1", "closed": account.closed})
+			}
+		}
+		json.NewEncoder(w).Encode(rows)
+	}))
+	t.Cleanup(server.Close)
+	return newSupabaseClient(supabaseConfig{URL: server.URL, ServiceRoleKey: "test"})
+}
+
+// newTestPostgresAccounts loads the fixture into a temporary accounts table
+// on TEST_DATABASE_URL, which shadows any real one for the connection.
+func newTestPostgresAccounts(t *testing.T) dataStore {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// The temporary table only exists on the connection that created it
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `create temporary table accounts (
+		id text, budget_id text, name text, account_type text,
+		balance numeric, is_on_budget boolean, closed boolean)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range openAccountsFixture {
+		if _, err := db.ExecContext(ctx,
+			`insert into accounts (id, budget_id, name, account_type, closed) values ($1, 'b1', $1, 'checking', $2)`,
+			account.id, account.closed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &postgresStore{db: db}
+}
@@ -0,0 +1,4 @@
+-- Budgets go back to following the server feature settings only
+
+alter table public.budgets
+  drop column if exists features;
@@ -0,0 +1,8 @@
+-- ============================================
+-- PER-BUDGET FEATURE SWITCHES
+-- ============================================
+-- Keys are feature names (aiChat, voice, shortcuts); false turns the
+-- feature off for the budget. Missing keys follow the server settings.
+
+alter table public.budgets
+  add column if not exists features jsonb not null default '{}'::jsonb;
@@ -0,0 +1,2 @@
+alter table public.scheduled_transactions
+  drop column if exists anchor_day;
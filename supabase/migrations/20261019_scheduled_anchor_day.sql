@@ -0,0 +1,10 @@
+-- ============================================
+-- SCHEDULED TRANSACTION ANCHOR DAY
+-- ============================================
+-- Day of month that monthly and yearly schedules fall on. next_date is
+-- clamped in shorter months (Jan 31 -> Feb 28), and the anchor lets the
+-- schedule return to the 31st afterwards. The scheduler fills it in from
+-- next_date the first time it posts a schedule.
+
+alter table public.scheduled_transactions
+  add column if not exists anchor_day smallint check (anchor_day between 1 and 31);
@@ -0,0 +1,53 @@
+drop trigger if exists monthly_budget_available on public.monthly_budgets;
+drop function if exists public.set_monthly_budget_available();
+
+-- Back to folding last month's available into this month's
+create or replace function public.recalculate_monthly_budget(p_category_id uuid, p_month date)
+returns void as $$
+declare
+  v_budgeted numeric(12,2);
+  v_activity numeric(12,2);
+  v_prev_available numeric(12,2);
+  v_new_available numeric(12,2);
+  v_first_of_month date;
+  v_first_of_prev_month date;
+begin
+  -- Normalize to first of month
+  v_first_of_month := date_trunc('month', p_month)::date;
+  v_first_of_prev_month := (date_trunc('month', p_month) - interval '1 month')::date;
+
+  -- Get current month's budgeted amount
+  select coalesce(budgeted, 0) into v_budgeted
+  from monthly_budgets
+  where category_id = p_category_id and month = v_first_of_month;
+
+  -- Calculate activity (sum of transactions for this category in this month)
+  select coalesce(sum(t.amount), 0) into v_activity
+  from transactions t
+  join accounts a on t.account_id = a.id
+  join categories c on t.category_id = c.id
+  where t.category_id = p_category_id
+    and date_trunc('month', t.date) = v_first_of_month
+    and a.is_on_budget = true;
+
+  -- Get previous month's available (carried over)
+  select coalesce(available, 0) into v_prev_available
+  from monthly_budgets
+  where category_id = p_category_id and month = v_first_of_prev_month;
+
+  -- Calculate new available: carried over + budgeted + activity (activity is negative for expenses)
+  v_new_available := v_prev_available + v_budgeted + v_activity;
+
+  -- Upsert the monthly budget
+  insert into monthly_budgets (category_id, month, budgeted, activity, available)
+  values (p_category_id, v_first_of_month, v_budgeted, v_activity, v_new_available)
+  on conflict (category_id, month)
+  do update set
+    activity = v_activity,
+    available = v_new_available,
+    updated_at = now();
+end;
+$$ language plpgsql security definer;
+
+alter table public.monthly_budgets
+  drop column if exists carryover;
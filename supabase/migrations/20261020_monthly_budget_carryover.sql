@@ -0,0 +1,55 @@
+-- ============================================
+-- MONTHLY BUDGET CARRYOVER
+-- ============================================
+-- Amount each category brings forward from the previous month, so
+-- available = carryover + budgeted + activity. Filled in by the server's
+-- month rollover job; a trigger keeps available in step with the other
+-- three columns on every write.
+
+alter table public.monthly_budgets
+  add column if not exists carryover numeric(12,2) not null default 0;
+
+-- Rows whose available already included last month's balance keep it, now
+-- as carryover
+update public.monthly_budgets
+set carryover = coalesce(available, 0) - coalesce(budgeted, 0) - coalesce(activity, 0)
+where coalesce(available, 0) <> carryover + coalesce(budgeted, 0) + coalesce(activity, 0);
+
+create or replace function public.set_monthly_budget_available()
+returns trigger as $$
+begin
+  new.available := coalesce(new.carryover, 0) + coalesce(new.budgeted, 0) + coalesce(new.activity, 0);
+  return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists monthly_budget_available on public.monthly_budgets;
+create trigger monthly_budget_available
+  before insert or update on public.monthly_budgets
+  for each row execute procedure public.set_monthly_budget_available();
+
+-- Recalculate activity only. carryover is written by the server's month
+-- rollover job alone, with the configured overspending rules, so the
+-- function keeps whatever it holds.
+create or replace function public.recalculate_monthly_budget(p_category_id uuid, p_month date)
+returns void as $$
+declare
+  v_month date := date_trunc('month', p_month)::date;
+  v_activity numeric(12,2);
+begin
+  select coalesce(sum(t.amount), 0) into v_activity
+  from public.transactions t
+  join public.accounts a on t.account_id = a.id
+  where t.category_id = p_category_id
+    and a.is_on_budget = true
+    and t.date >= v_month and t.date < (v_month + interval '1 month')::date;
+
+  -- available is set by the monthly_budget_available trigger
+  insert into public.monthly_budgets (category_id, month, activity)
+  values (p_category_id, v_month, v_activity)
+  on conflict (category_id, month)
+  do update set
+    activity = excluded.activity,
+    updated_at = now();
+end;
+$$ language plpgsql security definer;
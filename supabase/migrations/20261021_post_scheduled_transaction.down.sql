@@ -0,0 +1 @@
+drop function if exists public.post_scheduled_transaction(uuid, date, date, smallint);
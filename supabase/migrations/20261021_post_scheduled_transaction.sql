@@ -0,0 +1,45 @@
+-- ============================================
+-- POST SCHEDULED TRANSACTION
+-- ============================================
+-- Posts one occurrence of a scheduled transaction atomically: claims it by
+-- moving next_date on only while it still equals p_date, inserts the
+-- transaction and adjusts the account balance. Returns the new
+-- transaction's id, or null when the occurrence was already claimed by
+-- another runner. Runs with the caller's rights, so row level security
+-- still applies to anyone but the service role.
+
+create or replace function public.post_scheduled_transaction(
+  p_scheduled_id uuid,
+  p_date date,
+  p_next_date date,
+  p_anchor_day smallint
+)
+returns uuid as $$
+declare
+  v_scheduled public.scheduled_transactions%rowtype;
+  v_transaction_id uuid;
+begin
+  update public.scheduled_transactions
+  set next_date = p_next_date,
+      anchor_day = coalesce(p_anchor_day, anchor_day),
+      updated_at = now()
+  where id = p_scheduled_id and next_date = p_date
+  returning * into v_scheduled;
+
+  if not found then
+    return null;
+  end if;
+
+  insert into public.transactions (account_id, category_id, payee_id, date, amount, memo, cleared, approved)
+  values (v_scheduled.account_id, v_scheduled.category_id, v_scheduled.payee_id,
+          p_date, v_scheduled.amount, v_scheduled.memo, false, true)
+  returning id into v_transaction_id;
+
+  update public.accounts
+  set balance = coalesce(balance, 0) + v_scheduled.amount,
+      updated_at = now()
+  where id = v_scheduled.account_id;
+
+  return v_transaction_id;
+end;
+$$ language plpgsql;
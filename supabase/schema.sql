@@ -40,6 +40,7 @@ create table if not exists budgets (
   user_id uuid references profiles(id) on delete cascade not null,
   name text not null,
   currency_code char(3) default 'USD',
+  features jsonb not null default '{}'::jsonb, -- per-budget feature switches, e.g. {"voice": false}
   created_at timestamptz default now(),
   updated_at timestamptz default now()
 );
@@ -120,6 +121,7 @@ create table if not exists monthly_budgets (
   budgeted numeric(12,2) default 0,
   activity numeric(12,2) default 0,
   available numeric(12,2) default 0,
+  carryover numeric(12,2) not null default 0, -- Brought forward from the previous month
   created_at timestamptz default now(),
   updated_at timestamptz default now(),
   unique(category_id, month)
@@ -165,6 +167,7 @@ create table if not exists scheduled_transactions (
   payee_id uuid references payees(id),
   frequency text not null check (frequency in ('daily', 'weekly', 'biweekly', 'monthly', 'yearly')),
   next_date date not null,
+  anchor_day smallint check (anchor_day between 1 and 31),
   amount numeric(12,2) not null,
   memo text,
   created_at timestamptz default now(),
@@ -318,50 +321,42 @@ create index if not exists idx_net_worth_snapshots_budget_date on net_worth_snap
 -- FUNCTIONS FOR BUDGET CALCULATIONS
 -- ============================================
 
--- Function to recalculate monthly budget available amount
+-- Keep available = carryover + budgeted + activity on every write
+create or replace function set_monthly_budget_available()
+returns trigger as $$
+begin
+  new.available := coalesce(new.carryover, 0) + coalesce(new.budgeted, 0) + coalesce(new.activity, 0);
+  return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists monthly_budget_available on monthly_budgets;
+create trigger monthly_budget_available
+  before insert or update on monthly_budgets
+  for each row execute procedure set_monthly_budget_available();
+
+-- Recalculate a category's month from its transactions. carryover is only
+-- written by the server's month rollover job, which applies the configured
+-- overspending rules (MONTH_ROLLOVER_*_OVERSPENDING), so it is kept as is.
 create or replace function recalculate_monthly_budget(p_category_id uuid, p_month date)
 returns void as $$
 declare
-  v_budgeted numeric(12,2);
+  v_month date := date_trunc('month', p_month)::date;
   v_activity numeric(12,2);
-  v_prev_available numeric(12,2);
-  v_new_available numeric(12,2);
-  v_first_of_month date;
-  v_first_of_prev_month date;
 begin
-  -- Normalize to first of month
-  v_first_of_month := date_trunc('month', p_month)::date;
-  v_first_of_prev_month := (date_trunc('month', p_month) - interval '1 month')::date;
-  
-  -- Get current month's budgeted amount
-  select coalesce(budgeted, 0) into v_budgeted
-  from monthly_budgets
-  where category_id = p_category_id and month = v_first_of_month;
-  
-  -- Calculate activity (sum of transactions for this category in this month)
   select coalesce(sum(t.amount), 0) into v_activity
   from transactions t
   join accounts a on t.account_id = a.id
-  join categories c on t.category_id = c.id
   where t.category_id = p_category_id
-    and date_trunc('month', t.date) = v_first_of_month
-    and a.is_on_budget = true;
-  
-  -- Get previous month's available (carried over)
-  select coalesce(available, 0) into v_prev_available
-  from monthly_budgets
-  where category_id = p_category_id and month = v_first_of_prev_month;
-  
-  -- Calculate new available: carried over + budgeted + activity (activity is negative for expenses)
-  v_new_available := v_prev_available + v_budgeted + v_activity;
-  
-  -- Upsert the monthly budget
-  insert into monthly_budgets (category_id, month, budgeted, activity, available)
-  values (p_category_id, v_first_of_month, v_budgeted, v_activity, v_new_available)
+    and a.is_on_budget = true
+    and t.date >= v_month and t.date < (v_month + interval '1 month')::date;
+
+  -- available is set by the monthly_budget_available trigger
+  insert into monthly_budgets (category_id, month, activity)
+  values (p_category_id, v_month, v_activity)
   on conflict (category_id, month)
   do update set
-    activity = v_activity,
-    available = v_new_available,
+    activity = excluded.activity,
     updated_at = now();
 end;
 $$ language plpgsql security definer;
@@ -398,6 +393,46 @@ begin
 end;
 $$ language plpgsql security definer;
 
+-- Post one occurrence of a scheduled transaction: claim it by moving
+-- next_date on while it still equals p_date, insert the transaction and
+-- adjust the account balance, all or nothing. Returns the transaction id,
+-- or null when another runner claimed the occurrence first.
+create or replace function post_scheduled_transaction(
+  p_scheduled_id uuid,
+  p_date date,
+  p_next_date date,
+  p_anchor_day smallint
+)
+returns uuid as $$
+declare
+  v_scheduled scheduled_transactions%rowtype;
+  v_transaction_id uuid;
+begin
+  update scheduled_transactions
+  set next_date = p_next_date,
+      anchor_day = coalesce(p_anchor_day, anchor_day),
+      updated_at = now()
+  where id = p_scheduled_id and next_date = p_date
+  returning * into v_scheduled;
+
+  if not found then
+    return null;
+  end if;
+
+  insert into transactions (account_id, category_id, payee_id, date, amount, memo, cleared, approved)
+  values (v_scheduled.account_id, v_scheduled.category_id, v_scheduled.payee_id,
+          p_date, v_scheduled.amount, v_scheduled.memo, false, true)
+  returning id into v_transaction_id;
+
+  update accounts
+  set balance = coalesce(balance, 0) + v_scheduled.amount,
+      updated_at = now()
+  where id = v_scheduled.account_id;
+
+  return v_transaction_id;
+end;
+$$ language plpgsql;
+
 -- ============================================
 -- API KEYS (for iOS Shortcuts integration)
 -- ============================================
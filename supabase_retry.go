@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	supabaseRetryBase     = 200 * time.Millisecond
+	supabaseRetryMaxDelay = 5 * time.Second
+)
+
+// supabaseError is a non-2xx PostgREST response. Code, Message, Details and
+// Hint mirror the PostgREST error body when one was returned.
+type supabaseError struct {
+	Method     string        `json:"-"`
+	Path       string        `json:"-"`
+	StatusCode int           `json:"-"`
+	RetryAfter time.Duration `json:"-"`
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+	Details    string        `json:"details"`
+	Hint       string        `json:"hint"`
+}
+
+func (e *supabaseError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Code)
+	}
+	if e.Hint != "" {
+		msg = fmt.Sprintf("%s; hint: %s", msg, e.Hint)
+	}
+	return fmt.Sprintf("supabase %s %s failed with %d: %s", e.Method, e.Path, e.StatusCode, msg)
+}
+
+// temporary reports whether the failure is worth retrying.
+func (e *supabaseError) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func newSupabaseError(method, path string, resp *http.Response, body []byte) *supabaseError {
+	apiErr := &supabaseError{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(truncateString(string(body), 500))
+	}
+	apiErr.Method = method
+	apiErr.Path = path
+	apiErr.StatusCode = resp.StatusCode
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	return apiErr
+}
+
+// isIdempotentRequest reports whether a request can safely be sent again.
+// Upserts are idempotent even though they are POSTs.
+func isIdempotentRequest(method, prefer string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return strings.Contains(prefer, "resolution=merge-duplicates")
+	}
+	return false
+}
+
+// shouldRetrySupabase decides whether err is a transient failure.
+func shouldRetrySupabase(err error) bool {
+	var apiErr *supabaseError
+	if errors.As(err, &apiErr) {
+		return apiErr.temporary()
+	}
+	// Transport errors (connection refused, reset, timeout)
+	return err != nil && !errors.Is(err, errCircuitOpen)
+}
+
+// countsAsOutage reports whether err should trip the circuit breaker.
+// Client errors mean Supabase is up and answering, and a cancelled request
+// context means our caller went away rather than Supabase.
+func countsAsOutage(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *supabaseError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return err != nil
+}
+
+// retryDelay returns the wait before the given retry attempt (1-based),
+// using full-jitter exponential backoff unless the server sent Retry-After.
+func retryDelay(attempt int, err error) time.Duration {
+	var apiErr *supabaseError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	ceiling := supabaseRetryBase << uint(attempt-1)
+	if ceiling > supabaseRetryMaxDelay || ceiling <= 0 {
+		ceiling = supabaseRetryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter accepts both delta-seconds and HTTP-date forms.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Native TLS, for self-hosters without a reverse proxy. Either serve a
+// certificate from files (reloaded when they change, or on SIGHUP) or let
+// ACME issue one for TLS_AUTOCERT_DOMAINS.
+type tlsConfig struct {
+	CertFile       string
+	KeyFile        string
+	ReloadInterval time.Duration
+
+	AutocertDomains  string
+	AutocertEmail    string
+	AutocertCacheDir string
+	ACMEDirectoryURL string
+	ACMECAFile       string // trust a private ACME server such as Pebble
+
+	// Plain HTTP listener that redirects to HTTPS and answers ACME
+	// http-01 challenges; empty disables it
+	HTTPPort string
+
+	HSTSMaxAge int
+}
+
+func loadTLSConfig(src *configSource) tlsConfig {
+	return tlsConfig{
+		CertFile:         src.getEnv("TLS_CERT_FILE", ""),
+		KeyFile:          src.getEnv("TLS_KEY_FILE", ""),
+		ReloadInterval:   src.getEnvDuration("TLS_RELOAD_INTERVAL", time.Minute),
+		AutocertDomains:  src.getEnv("TLS_AUTOCERT_DOMAINS", ""),
+		AutocertEmail:    src.getEnv("TLS_AUTOCERT_EMAIL", ""),
+		AutocertCacheDir: src.getEnv("TLS_AUTOCERT_CACHE_DIR", "./certs"),
+		ACMEDirectoryURL: src.getEnv("TLS_ACME_DIRECTORY_URL", autocert.DefaultACMEDirectory),
+		ACMECAFile:       src.getEnv("TLS_ACME_CA_FILE", ""),
+		HTTPPort:         src.getEnv("TLS_HTTP_PORT", ""),
+		HSTSMaxAge:       src.getEnvInt("TLS_HSTS_MAX_AGE", 31536000),
+	}
+}
+
+func (c tlsConfig) enabled() bool {
+	return c.AutocertDomains != "" || (c.CertFile != "" && c.KeyFile != "")
+}
+
+// certReloader serves a certificate from disk, reloading it when the files
+// change so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration // between checks for changed files
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = r.latestModTime()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reloadIfChanged reloads the pair when either file is newer than the
+// loaded one. A broken pair keeps the previous certificate in service.
+func (r *certReloader) reloadIfChanged(force bool) {
+	r.mu.RLock()
+	loaded := r.modTime
+	r.mu.RUnlock()
+
+	if !force && !r.latestModTime().After(loaded) {
+		return
+	}
+	if err := r.load(); err != nil {
+		logger.Error("TLS certificate reload failed, keeping the current certificate", "error", err)
+		return
+	}
+	logger.Info("TLS certificate reloaded", "certFile", r.certFile)
+}
+
+// watch polls for changed files and reloads on SIGHUP until ctx is done.
+func (r *certReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reloadIfChanged(false)
+		case <-hup:
+			r.reloadIfChanged(true)
+		}
+	}
+}
+
+// newTLSConfig builds the TLS configuration and, with ACME, the manager
+// that also answers http-01 challenges on the plain listener.
+func newTLSConfig(cfg tlsConfig, lc *lifecycle) (*tls.Config, *autocert.Manager, error) {
+	if cfg.AutocertDomains != "" {
+		var domains []string
+		for _, domain := range strings.Split(cfg.AutocertDomains, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+
+		client := &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+		if cfg.ACMECAFile != "" {
+			pem, err := os.ReadFile(cfg.ACMECAFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading TLS_ACME_CA_FILE: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, nil, errors.New("TLS_ACME_CA_FILE contains no certificates")
+			}
+			client.HTTPClient = &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			}
+		}
+
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+			Client:     client,
+		}
+		config := manager.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config, manager, nil
+	}
+
+	reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile, cfg.ReloadInterval)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	lc.goJob("tls-reload", reloader.watch)
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}, nil, nil
+}
+
+// newServers returns the main listener on addr, over TLS when configured,
+// plus the plain HTTP redirect listener when TLS_HTTP_PORT is set.
+func newServers(addr string, handler http.Handler, cfg *Config, lc *lifecycle) ([]*serverRunner, error) {
+	if !cfg.TLS.enabled() {
+		return []*serverRunner{listenAndServe(newHTTPServer(addr, handler, cfg.Server))}, nil
+	}
+
+	config, manager, err := newTLSConfig(cfg.TLS, lc)
+	if err != nil {
+		return nil, err
+	}
+	servers := []*serverRunner{listenAndServeTLS(newHTTPServer(addr, handler, cfg.Server), config)}
+
+	if cfg.TLS.HTTPPort != "" {
+		var redirect http.Handler = httpsRedirectHandler(handler, cfg.Port)
+		if manager != nil {
+			// Answers http-01 challenges and redirects everything else
+			redirect = manager.HTTPHandler(redirect)
+		}
+		servers = append(servers, listenAndServe(newHTTPServer("0.0.0.0:"+cfg.TLS.HTTPPort, redirect, cfg.Server)))
+	}
+	return servers, nil
+}
+
+// listenAndServeTLS serves server over TLS with the given config.
+func listenAndServeTLS(server *http.Server, config *tls.Config) *serverRunner {
+	server.TLSConfig = config
+	return &serverRunner{server: server, run: func() error {
+		// Certificates come from config.GetCertificate
+		return server.ListenAndServeTLS("", "")
+	}}
+}
+
+// httpsRedirectHandler sends plain HTTP requests to the HTTPS listener.
+// Health checks are answered directly so container probes keep working.
+// port is the HTTPS listener's.
+func httpsRedirectHandler(health http.Handler, port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/health") {
+			health.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// hstsMiddleware tells browsers to keep using HTTPS once they have seen it.
+func hstsMiddleware(maxAge int, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := "max-age=" + strconv.Itoa(maxAge) + "; includeSubDomains"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing on the OpenTelemetry SDK: W3C traceparent propagation and an
+// OTLP/HTTP (protobuf) or stdout exporter, configured with the standard
+// OTEL_* environment variables. The span wrapper below keeps call sites
+// independent of the SDK's API.
+
+type tracingConfig struct {
+	Exporter    string // otlp, console, stdout or none
+	Endpoint    string
+	Headers     string
+	ServiceName string
+	SampleRatio float64
+}
+
+func loadTracingConfig(src *configSource) tracingConfig {
+	return tracingConfig{
+		Exporter:    src.getEnvEnum("OTEL_TRACES_EXPORTER", "none", "otlp", "console", "stdout", "none"),
+		Endpoint:    src.getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", strings.TrimRight(src.getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "/")+"/v1/traces"),
+		Headers:     src.getEnv("OTEL_EXPORTER_OTLP_HEADERS", ""),
+		ServiceName: src.getEnv("OTEL_SERVICE_NAME", "yabt"),
+		SampleRatio: src.getEnvFloat("OTEL_TRACES_SAMPLER_ARG", 1.0),
+	}
+}
+
+type spanKind = trace.SpanKind
+
+const (
+	spanKindInternal = trace.SpanKindInternal
+	spanKindServer   = trace.SpanKindServer
+	spanKindClient   = trace.SpanKindClient
+)
+
+// Trace context travels in W3C traceparent headers.
+var tracePropagator = propagation.TraceContext{}
+
+// span is a single timed operation. All methods are safe on a nil span.
+type span struct {
+	otel trace.Span
+	kind spanKind
+}
+
+type spanKey struct{}
+
+// spanFromContext returns the active span, or nil.
+func spanFromContext(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// extractTraceparent continues the trace of an upstream caller, when its
+// request carries one.
+func extractTraceparent(ctx context.Context, header http.Header) context.Context {
+	return tracePropagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// injectTraceparent propagates the active span to an outbound request.
+func injectTraceparent(ctx context.Context, header http.Header) {
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// startSpan begins a child of the active (or remote) span in ctx, or a new
+// trace when there is none.
+func startSpan(ctx context.Context, name string, kind spanKind) (context.Context, *span) {
+	ctx, otelSpan := otel.Tracer("yabt").Start(ctx, name, trace.WithSpanKind(kind))
+	s := &span{otel: otelSpan, kind: kind}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) setAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	var attr attribute.KeyValue
+	switch v := value.(type) {
+	case bool:
+		attr = attribute.Bool(key, v)
+	case int:
+		attr = attribute.Int(key, v)
+	case int64:
+		attr = attribute.Int64(key, v)
+	case float64:
+		attr = attribute.Float64(key, v)
+	case string:
+		attr = attribute.String(key, v)
+	default:
+		attr = attribute.String(key, fmt.Sprint(v))
+	}
+	s.otel.SetAttributes(attr)
+}
+
+// setError marks the span as failed.
+func (s *span) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.otel.SetStatus(codes.Error, err.Error())
+}
+
+// setHTTPStatus records a response status, failing the span on 5xx (and,
+// for client spans, on 4xx as well).
+func (s *span) setHTTPStatus(code int) {
+	if s == nil {
+		return
+	}
+	s.setAttr("http.response.status_code", code)
+	if code >= 500 || (s.kind == spanKindClient && code >= 400) {
+		s.otel.SetStatus(codes.Error, "")
+	}
+}
+
+// traceID returns the span's trace ID, or "" before tracing is configured.
+func (s *span) traceID() string {
+	if s == nil || !s.otel.SpanContext().HasTraceID() {
+		return ""
+	}
+	return s.otel.SpanContext().TraceID().String()
+}
+
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.otel.End()
+}
+
+// configureTracing installs the process-wide tracer provider. Spans always
+// get IDs, so logs can be correlated, but are only exported when an
+// exporter is configured.
+func configureTracing(cfg tracingConfig, environment string) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", cfg.ServiceName),
+			attribute.String("service.version", version),
+			attribute.String("deployment.environment", environment),
+		)),
+	}
+
+	exporter, err := newSpanExporter(cfg)
+	if err != nil {
+		logger.Warn("Trace exporter disabled", "exporter", cfg.Exporter, "error", err)
+	}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(&countingExporter{SpanExporter: exporter}))
+	}
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(options...))
+	otel.SetTextMapPropagator(tracePropagator)
+}
+
+func newSpanExporter(cfg tracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "otlp":
+		return otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(cfg.Endpoint),
+			otlptracehttp.WithHeaders(parseOTLPHeaders(cfg.Headers)),
+		)
+	case "console", "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, nil
+	}
+}
+
+// countingExporter counts and logs spans lost to failed exports.
+type countingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e *countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		tracesDroppedTotal.add(float64(len(spans)))
+		logger.Warn("Trace export failed", "error", err)
+	}
+	return err
+}
+
+// shutdownTracing exports any queued spans, waiting at most until ctx is
+// done.
+func shutdownTracing(ctx context.Context) {
+	if provider, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		provider.Shutdown(ctx)
+	}
+}
+
+// parseOTLPHeaders parses the "key1=value1,key2=value2" header list format.
+func parseOTLPHeaders(value string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return headers
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTraceparentPropagation(t *testing.T) {
+	configureTracing(tracingConfig{Exporter: "none", ServiceName: "yabt", SampleRatio: 1}, "test")
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	incoming := http.Header{}
+	incoming.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	ctx := extractTraceparent(context.Background(), incoming)
+	ctx, span := startSpan(ctx, "GET /api/test", spanKindServer)
+	defer span.finish()
+	if got := span.traceID(); got != traceID {
+		t.Fatalf("traceID = %s, want %s", got, traceID)
+	}
+	if spanFromContext(ctx) != span {
+		t.Fatal("span not stored in the context")
+	}
+
+	outgoing := http.Header{}
+	injectTraceparent(ctx, outgoing)
+	parts := strings.Split(outgoing.Get("traceparent"), "-")
+	if len(parts) != 4 || parts[1] != traceID || parts[2] == "00f067aa0ba902b7" {
+		t.Errorf("outbound traceparent = %q, want a child of the incoming trace", outgoing.Get("traceparent"))
+	}
+}
+
+func TestStartSpanWithoutParent(t *testing.T) {
+	configureTracing(tracingConfig{Exporter: "none", ServiceName: "yabt", SampleRatio: 1}, "test")
+
+	_, first := startSpan(context.Background(), "job", spanKindInternal)
+	_, second := startSpan(context.Background(), "job", spanKindInternal)
+	defer first.finish()
+	defer second.finish()
+	if first.traceID() == "" || first.traceID() == second.traceID() {
+		t.Errorf("trace IDs %q and %q, want two new traces", first.traceID(), second.traceID())
+	}
+}